@@ -9,7 +9,7 @@ root="$(cd "$(dirname "${BASH_SOURCE[0]}" )" && pwd -P)"
 src="${root}/../source"
 dst="${root}/../build"
 stub="${root}/capi.go"
-capi_dir="${dst}/capi_build"
+capi_dir="${src}/capi"
 
 [ -d "$src" ] || die "source absent"
 [ -r "$stub" ] || die "stub unreadable"
@@ -24,14 +24,41 @@ case "$arch_in" in
   *)             die "unsupported arch" ;;
 esac
 
-trap 'rm -rf -- "$capi_dir"' EXIT INT TERM
+# The kernel patches add the hooks the capi package observes the tunnel
+# through. They are applied for the build only and reversed on exit.
+applied=()
+cleanup(){
+  local i
+  for (( i=${#applied[@]}-1; i>=0; i-- )); do
+    git -C "$src" apply -R "${applied[i]}" || log "revert failed:$(basename "${applied[i]}")"
+  done
+  rm -rf -- "$capi_dir"
+}
+trap cleanup EXIT INT TERM
+
+for p in "$root"/patches/*.patch; do
+  git -C "$src" apply --check "$p" 2>/dev/null || die "kernel patch does not apply:$(basename "$p")"
+  git -C "$src" apply "$p"
+  applied+=("$p")
+done
 
-cp "$stub" "$capi_dir/capi.go"
-cat > "$capi_dir/go.mod" << 'EOF'
-module mihomo-capi
+cp "$root"/*.go "$root"/*.h "$capi_dir/"
 
-go 1.21
-EOF
+# Stamp the version the way mihomo's Makefile does, falling back to
+# "unknown" for a source tarball without git metadata.
+version="${MIHOMO_VERSION:-}"
+if [ -z "$version" ]; then
+  version="unknown"
+  if git -C "$src" rev-parse --git-dir >/dev/null 2>&1; then
+    case "$(git -C "$src" branch --show-current)" in
+      Alpha) version="alpha-$(git -C "$src" rev-parse --short HEAD)" ;;
+      Beta)  version="beta-$(git -C "$src" rev-parse --short HEAD)" ;;
+      *)     version="$(git -C "$src" describe --tags 2>/dev/null || git -C "$src" rev-parse --short HEAD)" ;;
+    esac
+  fi
+fi
+build_time="$(date -u)"
+ldflags="-X 'github.com/metacubex/mihomo/constant.Version=${version}' -X 'github.com/metacubex/mihomo/constant.BuildTime=${build_time}'"
 
 build(){
   local a="$1"
@@ -39,10 +66,10 @@ build(){
   local static_out="${dst}/libmihomo_${a}.a"
   
   log "compile:${a} (dylib)"
-  ( cd "$capi_dir" && GOOS=darwin GOARCH="$a" CGO_ENABLED=1 GO111MODULE=on go build -trimpath -buildmode=c-shared -o "$dylib_out" ./capi.go )
+  ( cd "$src" && GOOS=darwin GOARCH="$a" CGO_ENABLED=1 GO111MODULE=on go build -trimpath -tags "${MIHOMO_TAGS:-}" -ldflags "$ldflags" -buildmode=c-shared -o "$dylib_out" ./capi )
   
   log "compile:${a} (static)"
-  ( cd "$capi_dir" && GOOS=darwin GOARCH="$a" CGO_ENABLED=1 GO111MODULE=on go build -trimpath -buildmode=c-archive -o "$static_out" ./capi.go )
+  ( cd "$src" && GOOS=darwin GOARCH="$a" CGO_ENABLED=1 GO111MODULE=on go build -trimpath -tags "${MIHOMO_TAGS:-}" -ldflags "$ldflags" -buildmode=c-archive -o "$static_out" ./capi )
   
   if [ "$a" = "${sets[0]}" ]; then
     ln -sf "libmihomo_${a}.dylib" "${dst}/libmihomo.dylib"
@@ -52,6 +79,7 @@ build(){
   local hdr="${dst}/libmihomo_${a}.h"
   [ -f "$hdr" ] || die "header missing:${a}"
   install -m 0644 "$hdr" "${dst}/libmihomo.h"
+  install -m 0644 "${root}/capi.h" "${dst}/capi.h"
   log "ready:${a}"
 }
 
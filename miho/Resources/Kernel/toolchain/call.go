@@ -0,0 +1,463 @@
+package main
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"unsafe"
+)
+
+// callResult is the envelope every MihomoCall reply is wrapped in. ok,
+// data and error are always present; code carries the ErrCode of a failed
+// export when there is one, and error the last error it recorded.
+type callResult struct {
+	OK    bool   `json:"ok"`
+	Code  int    `json:"code,omitempty"`
+	Data  any    `json:"data"`
+	Error string `json:"error"`
+}
+
+type codeError C.int
+
+func (e codeError) Error() string {
+	return fmt.Sprintf("error code %d", int(e))
+}
+
+type callHandler func(params json.RawMessage) (any, error)
+
+// cArgs collects the C strings built for one call so they can be freed
+// together once the export returns.
+type cArgs []*C.char
+
+func (a *cArgs) str(s string) *C.char {
+	p := C.CString(s)
+	*a = append(*a, p)
+	return p
+}
+
+func (a cArgs) free() {
+	for _, p := range a {
+		C.free(unsafe.Pointer(p))
+	}
+}
+
+func takeString(p *C.char) string {
+	s := C.GoString(p)
+	freeCString(p)
+	return s
+}
+
+func takeJSON(p *C.char) any {
+	if s := takeString(p); s != "" {
+		return json.RawMessage(s)
+	}
+	return nil
+}
+
+// codeResult turns an export's status into a result: negative codes fail,
+// zero succeeds with no data and anything else is a count.
+func codeResult(code C.int) (any, error) {
+	switch {
+	case code < 0:
+		return nil, codeError(code)
+	case code == 0:
+		return nil, nil
+	default:
+		return int(code), nil
+	}
+}
+
+func noParams(f func() *C.char) callHandler {
+	return func(json.RawMessage) (any, error) {
+		return takeJSON(f()), nil
+	}
+}
+
+func noParamsCode(f func() C.int) callHandler {
+	return func(json.RawMessage) (any, error) {
+		return codeResult(f())
+	}
+}
+
+func decodeParams(params json.RawMessage, v any) error {
+	if len(params) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return codeError(ErrCodeInvalidConfig)
+	}
+	return nil
+}
+
+// callHandlers maps MihomoCall methods onto the dedicated exports, so both
+// entry points share one implementation. Exports that need C callbacks have
+// no method here.
+var callHandlers = map[string]callHandler{
+	"version":             noParams(MihomoVersion),
+	"start":               noParamsCode(MihomoStart),
+	"stop":                noParamsCode(MihomoStop),
+	"getLastError":        func(json.RawMessage) (any, error) { return takeString(MihomoGetLastError()), nil },
+	"getMode":             func(json.RawMessage) (any, error) { return takeString(MihomoGetMode()), nil },
+	"getProxies":          noParams(MihomoGetProxies),
+	"getAllSelected":      noParams(MihomoGetAllSelected),
+	"getConnections":      noParams(MihomoGetConnections),
+	"closeAllConnections": noParamsCode(MihomoCloseAllConnections),
+	"getTotalTraffic":     noParams(MihomoGetTotalTraffic),
+	"getRuleProviders":    noParams(MihomoGetRuleProviders),
+	"getProxyProviders":   noParams(MihomoGetProxyProviders),
+	"getPorts":            noParams(MihomoGetPorts),
+	"getConfig":           noParams(MihomoGetConfig),
+	"getStatus":           noParams(MihomoGetStatus),
+	"getErrorStats":       noParams(MihomoGetErrorStats),
+	"getFakeIPRange":      func(json.RawMessage) (any, error) { return takeString(MihomoGetFakeIPRange()), nil },
+	"forceGC":             noParams(MihomoForceGC),
+	"flushDNSCache":       noParamsCode(MihomoFlushDNSCache),
+	"resume":              noParamsCode(MihomoResume),
+	"clearGlobalProxy":    noParamsCode(MihomoClearGlobalProxy),
+	"stopTun":             noParamsCode(MihomoStopTun),
+
+	"cancelDelayTests": func(json.RawMessage) (any, error) {
+		MihomoCancelDelayTests()
+		return nil, nil
+	},
+	"resetErrorStats": func(json.RawMessage) (any, error) {
+		MihomoResetErrorStats()
+		return nil, nil
+	},
+	"resetTraffic": func(json.RawMessage) (any, error) {
+		MihomoResetTraffic()
+		return nil, nil
+	},
+	"isPaused": func(json.RawMessage) (any, error) {
+		return MihomoIsPaused() != 0, nil
+	},
+	"init": func(params json.RawMessage) (any, error) {
+		var p struct {
+			Home   string `json:"home"`
+			Config string `json:"config"`
+		}
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		var args cArgs
+		defer args.free()
+		return codeResult(MihomoInit(args.str(p.Home), args.str(p.Config)))
+	},
+	"reinit": func(params json.RawMessage) (any, error) {
+		var p struct {
+			Home   string `json:"home"`
+			Config string `json:"config"`
+		}
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		var args cArgs
+		defer args.free()
+		return codeResult(MihomoReinit(args.str(p.Home), args.str(p.Config)))
+	},
+	"startFromBytes": func(params json.RawMessage) (any, error) {
+		var p struct {
+			Home string `json:"home"`
+			YAML string `json:"yaml"`
+		}
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		var args cArgs
+		defer args.free()
+		return codeResult(MihomoStartFromBytes(args.str(p.Home), args.str(p.YAML), C.int(len(p.YAML))))
+	},
+	"stopWithTimeout": func(params json.RawMessage) (any, error) {
+		var p struct {
+			TimeoutMs int `json:"timeoutMs"`
+		}
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		return codeResult(MihomoStopWithTimeout(C.int(p.TimeoutMs)))
+	},
+	"startTun": func(params json.RawMessage) (any, error) {
+		p := struct {
+			FD    int    `json:"fd"`
+			MTU   int    `json:"mtu"`
+			Stack string `json:"stack"`
+		}{FD: -1}
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		var args cArgs
+		defer args.free()
+		return codeResult(MihomoStartTun(C.int(p.FD), C.int(p.MTU), args.str(p.Stack)))
+	},
+	"setExternalController": func(params json.RawMessage) (any, error) {
+		var p struct {
+			Addr   string `json:"addr"`
+			Secret string `json:"secret"`
+		}
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		var args cArgs
+		defer args.free()
+		return codeResult(MihomoSetExternalController(args.str(p.Addr), args.str(p.Secret)))
+	},
+	"patchConfig": func(params json.RawMessage) (any, error) {
+		var args cArgs
+		defer args.free()
+		return codeResult(MihomoPatchConfig(args.str(string(params))))
+	},
+	"validateConfig": func(params json.RawMessage) (any, error) {
+		var p struct {
+			Path string `json:"path"`
+		}
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		var args cArgs
+		defer args.free()
+		if result := takeString(MihomoValidateConfig(args.str(p.Path))); result != "" {
+			return json.RawMessage(result), errors.New("invalid config")
+		}
+		return nil, nil
+	},
+	"setMode": func(params json.RawMessage) (any, error) {
+		var p struct {
+			Mode string `json:"mode"`
+		}
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		var args cArgs
+		defer args.free()
+		return codeResult(MihomoSetMode(args.str(p.Mode)))
+	},
+	"setLogLevel": func(params json.RawMessage) (any, error) {
+		var p struct {
+			Level string `json:"level"`
+		}
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		var args cArgs
+		defer args.free()
+		return codeResult(MihomoSetLogLevel(args.str(p.Level)))
+	},
+	"getSelected": func(params json.RawMessage) (any, error) {
+		var p struct {
+			Group string `json:"group"`
+		}
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		var args cArgs
+		defer args.free()
+		return takeString(MihomoGetSelected(args.str(p.Group))), nil
+	},
+	"selectProxy": func(params json.RawMessage) (any, error) {
+		var p struct {
+			Group string `json:"group"`
+			Name  string `json:"name"`
+		}
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		var args cArgs
+		defer args.free()
+		return codeResult(MihomoSelectProxy(args.str(p.Group), args.str(p.Name)))
+	},
+	"setGlobalProxy": func(params json.RawMessage) (any, error) {
+		var p struct {
+			Name string `json:"name"`
+		}
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		var args cArgs
+		defer args.free()
+		return codeResult(MihomoSetGlobalProxy(args.str(p.Name)))
+	},
+	"setAllowLan": func(params json.RawMessage) (any, error) {
+		var p struct {
+			Allow       bool   `json:"allow"`
+			BindAddress string `json:"bindAddress"`
+		}
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		allow := C.int(0)
+		if p.Allow {
+			allow = 1
+		}
+		var args cArgs
+		defer args.free()
+		return codeResult(MihomoSetAllowLan(allow, args.str(p.BindAddress)))
+	},
+	"setSelectionCachePath": func(params json.RawMessage) (any, error) {
+		var p struct {
+			Path string `json:"path"`
+		}
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		var args cArgs
+		defer args.free()
+		return codeResult(MihomoSetSelectionCachePath(args.str(p.Path)))
+	},
+	"startAndWait": func(params json.RawMessage) (any, error) {
+		var p struct {
+			TimeoutMs int `json:"timeoutMs"`
+		}
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		return codeResult(MihomoStartAndWait(C.int(p.TimeoutMs)))
+	},
+	"closeConnection": func(params json.RawMessage) (any, error) {
+		var p struct {
+			ID string `json:"id"`
+		}
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		var args cArgs
+		defer args.free()
+		return codeResult(MihomoCloseConnection(args.str(p.ID)))
+	},
+	"fakeIPToHost": func(params json.RawMessage) (any, error) {
+		var p struct {
+			IP string `json:"ip"`
+		}
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		var args cArgs
+		defer args.free()
+		return takeString(MihomoFakeIPToHost(args.str(p.IP))), nil
+	},
+	"getSubscriptionInfo": func(params json.RawMessage) (any, error) {
+		var p struct {
+			Provider string `json:"provider"`
+		}
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		var args cArgs
+		defer args.free()
+		return takeJSON(MihomoGetSubscriptionInfo(args.str(p.Provider))), nil
+	},
+	"matchRule": func(params json.RawMessage) (any, error) {
+		var p struct {
+			Host    string `json:"host"`
+			Port    int    `json:"port"`
+			Network string `json:"network"`
+		}
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		var args cArgs
+		defer args.free()
+		return takeJSON(MihomoMatchRule(args.str(p.Host), C.int(p.Port), args.str(p.Network))), nil
+	},
+	"pause": func(params json.RawMessage) (any, error) {
+		var p struct {
+			KeepExisting bool `json:"keepExisting"`
+		}
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		keepExisting := C.int(0)
+		if p.KeepExisting {
+			keepExisting = 1
+		}
+		return codeResult(MihomoPause(keepExisting))
+	},
+	"setMemoryLimit": func(params json.RawMessage) (any, error) {
+		var p struct {
+			Bytes int64 `json:"bytes"`
+		}
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		return codeResult(MihomoSetMemoryLimit(C.longlong(p.Bytes)))
+	},
+	"setTrafficInterval": func(params json.RawMessage) (any, error) {
+		var p struct {
+			Ms int `json:"ms"`
+		}
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		return codeResult(MihomoSetTrafficInterval(C.int(p.Ms)))
+	},
+	"hasFeature": func(params json.RawMessage) (any, error) {
+		var p struct {
+			Name string `json:"name"`
+		}
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		var args cArgs
+		defer args.free()
+		return MihomoHasFeature(args.str(p.Name)) != 0, nil
+	},
+}
+
+// MihomoCall runs the named method with a JSON object of parameters and
+// returns a {"ok","data","error"} envelope, so a binding can reach every
+// callback-free export through one symbol. Method and parameter names are
+// the camelCase forms of the dedicated exports and their arguments. A
+// failing export's code goes in "code" and its last error in "error".
+//
+//export MihomoCall
+func MihomoCall(cMethod *C.char, cParamsJSON *C.char) *C.char {
+	method := ""
+	if cMethod != nil {
+		method = C.GoString(cMethod)
+	}
+	var params json.RawMessage
+	if cParamsJSON != nil {
+		if s := C.GoString(cParamsJSON); s != "" {
+			params = json.RawMessage(s)
+		}
+	}
+
+	data, _ := json.Marshal(call(method, params))
+	return newCString(string(data))
+}
+
+// call dispatches one MihomoCall method and builds its envelope.
+func call(method string, params json.RawMessage) callResult {
+	var result callResult
+	if handler, ok := callHandlers[method]; !ok {
+		result.Error = fmt.Sprintf("unknown method %q", method)
+	} else {
+		// Exports that fail before taking the write lock record nothing,
+		// so the last error is cleared first and a stale one never ends
+		// up in the envelope. getLastError is the one method that must
+		// see it.
+		if method != "getLastError" {
+			release, _ := seize(true, false)
+			release()
+		}
+
+		data, err := handler(params)
+		result.OK = err == nil
+		result.Data = data
+		if err != nil {
+			result.Error = err.Error()
+			var code codeError
+			if errors.As(err, &code) {
+				result.Code = int(code)
+				if msg := takeString(MihomoGetLastError()); msg != "" {
+					result.Error = msg
+				}
+			}
+		}
+	}
+	return result
+}
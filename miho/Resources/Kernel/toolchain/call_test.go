@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestCodeResult(t *testing.T) {
+	if data, err := codeResult(ErrCodeSuccess); data != nil || err != nil {
+		t.Errorf("codeResult(0) = %v, %v; want no data and no error", data, err)
+	}
+	if data, err := codeResult(3); data != 3 || err != nil {
+		t.Errorf("codeResult(3) = %v, %v; want the count", data, err)
+	}
+	if data, err := codeResult(ErrCodeNotStarted); data != nil || !errors.Is(err, codeError(ErrCodeNotStarted)) {
+		t.Errorf("codeResult(%d) = %v, %v; want the code as an error", ErrCodeNotStarted, data, err)
+	}
+}
+
+func TestCallEnvelope(t *testing.T) {
+	for _, tt := range []struct {
+		method string
+		params string
+		want   string
+	}{
+		{"noSuchMethod", "", `{"ok":false,"data":null,"error":"unknown method \"noSuchMethod\""}`},
+		{"isPaused", "", `{"ok":true,"data":false,"error":""}`},
+		{"setMode", `{"mode":`, `{"ok":false,"code":-5,"data":null,"error":"error code -5"}`},
+		{"selectProxy", `{"group":"G","name":"DIRECT"}`, `{"ok":false,"code":-7,"data":null,"error":"core not initialized"}`},
+	} {
+		data, _ := json.Marshal(call(tt.method, json.RawMessage(tt.params)))
+		if string(data) != tt.want {
+			t.Errorf("call(%q, %q) = %s; want %s", tt.method, tt.params, data, tt.want)
+		}
+	}
+}
+
+func TestCallGetLastError(t *testing.T) {
+	call("selectProxy", json.RawMessage(`{"group":"G","name":"DIRECT"}`))
+	if result := call("getLastError", nil); !result.OK || result.Data != "core not initialized" {
+		t.Errorf("getLastError = %+v; want the error left by the previous export", result)
+	}
+	if result := call("getLastError", nil); result.Data != "core not initialized" {
+		t.Errorf("getLastError cleared the error it reports: %+v", result)
+	}
+}
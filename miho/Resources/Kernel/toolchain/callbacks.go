@@ -0,0 +1,92 @@
+package main
+
+/*
+#include "capi.h"
+*/
+import "C"
+
+import (
+	"unsafe"
+
+	"github.com/metacubex/mihomo/tunnel"
+)
+
+// Callbacks are invoked synchronously while gate is held, so they must not
+// call back into the core. Setters and the Clear functions take the write
+// lock and every emitter checks its slot under at least the read lock, so
+// once a Clear returns the old pointer is never called again.
+
+//export MihomoSetStateChangeCallback
+func MihomoSetStateChangeCallback(cb C.MihomoStateChangeCallback, ctx unsafe.Pointer) C.int {
+	release, _ := seize(true, false)
+	defer release()
+
+	core.stateChangeCb = cb
+	core.stateChangeCtx = ctx
+	return ErrCodeSuccess
+}
+
+//export MihomoClearStateChangeCallback
+func MihomoClearStateChangeCallback() {
+	release, _ := seize(true, false)
+	defer release()
+
+	core.stateChangeCb = nil
+	core.stateChangeCtx = nil
+}
+
+// notifyState reports the current core state to the registered listener.
+// The caller must hold gate exclusively.
+func notifyState() {
+	emitState(core.state())
+}
+
+// emitState reports a transitional state that core.state() cannot derive,
+// such as STARTING. A state equal to the last one reported is dropped, so
+// the listener only hears about real transitions. The caller must hold gate
+// exclusively.
+func emitState(state C.MihomoCoreState) {
+	if state == core.reportedState {
+		return
+	}
+	core.reportedState = state
+	C.mihomo_invoke_state(core.stateChangeCb, state, core.stateChangeCtx)
+}
+
+// MihomoSetModeChangeCallback registers a callback that receives the new
+// routing mode as "rule", "global" or "direct" whenever it changes on the
+// running core: through MihomoSetMode, a patch carrying mode, or the global
+// proxy override.
+//
+//export MihomoSetModeChangeCallback
+func MihomoSetModeChangeCallback(cb C.MihomoModeChangeCallback, ctx unsafe.Pointer) C.int {
+	release, _ := seize(true, false)
+	defer release()
+
+	core.modeChangeCb = cb
+	core.modeChangeCtx = ctx
+	return ErrCodeSuccess
+}
+
+//export MihomoClearModeChangeCallback
+func MihomoClearModeChangeCallback() {
+	release, _ := seize(true, false)
+	defer release()
+
+	core.modeChangeCb = nil
+	core.modeChangeCtx = nil
+}
+
+// setMode switches the tunnel to mode and reports it to the mode listener
+// when it differs from the current one. The caller must hold gate
+// exclusively.
+func setMode(mode tunnel.TunnelMode) {
+	if tunnel.Mode() == mode {
+		return
+	}
+	tunnel.SetMode(mode)
+
+	name := C.CString(mode.String())
+	C.mihomo_invoke_mode(core.modeChangeCb, name, core.modeChangeCtx)
+	C.free(unsafe.Pointer(name))
+}
@@ -4,7 +4,6 @@ import "C"
 import (
 	"errors"
 	"sync"
-	"unsafe"
 )
 
 /*
@@ -20,6 +19,8 @@ const (
 	ErrCodeNotStarted      = -4
 	ErrCodeInvalidConfig   = -5
 	ErrCodeInternalError   = -6
+	ErrCodeNotInitialized  = -7
+	ErrCodeNotHotReload    = -8
 )
 
 var (
@@ -108,15 +109,13 @@ func mihomo_destroy(handle C.longlong) C.int {
 
 //export mihomo_free_string
 func mihomo_free_string(ptr *C.char) {
-	if ptr != nil {
-		C.free(unsafe.Pointer(ptr))
-	}
+	freeCString(ptr)
 }
 
 //export mihomo_get_last_error
 func mihomo_get_last_error() *C.char {
 	errorMsg := "No error"
-	return C.CString(errorMsg)
+	return newCString(errorMsg)
 }
 
 func main() {}
@@ -0,0 +1,92 @@
+#ifndef MIHOMO_CAPI_H
+#define MIHOMO_CAPI_H
+
+#include <stdint.h>
+#include <stdlib.h>
+
+// Strings passed to a callback are owned by the core and only valid for the
+// duration of the call; copy them if they are needed afterwards.
+
+// Core states reported through MihomoStateChangeCallback. IDLE covers a core
+// that has not been started since it was initialised; STOPPED follows a
+// stop. STARTING and STOPPING are fired before the work begins, so a
+// STARTING is always followed by RUNNING or ERROR. ERROR means the last start
+// failed and persists until the next start or init. A state is only reported
+// when it differs from the one before; mode changes go to
+// MihomoModeChangeCallback instead.
+typedef int MihomoCoreState;
+#define MIHOMO_STATE_STOPPED 0
+#define MIHOMO_STATE_STARTING 1
+#define MIHOMO_STATE_RUNNING 2
+#define MIHOMO_STATE_PAUSED 3
+#define MIHOMO_STATE_STOPPING 4
+#define MIHOMO_STATE_IDLE 5
+#define MIHOMO_STATE_ERROR 6
+
+typedef struct { uint64_t timestamp_ms, up, down; } MihomoTrafficSample;
+typedef struct { uint64_t timestamp_ms, inuse, limit; } MihomoMemorySample;
+
+typedef void (*MihomoTrafficCallback)(const MihomoTrafficSample* sample, void* ctx);
+typedef void (*MihomoMemoryCallback)(const MihomoMemorySample* sample, void* ctx);
+typedef void (*MihomoStateChangeCallback)(MihomoCoreState state, void* ctx);
+typedef void (*MihomoModeChangeCallback)(const char* mode, void* ctx);
+#define MIHOMO_LOG_DEBUG 0
+#define MIHOMO_LOG_INFO 1
+#define MIHOMO_LOG_WARNING 2
+#define MIHOMO_LOG_ERROR 3
+
+typedef void (*MihomoLogCallback)(const char* line, void* ctx);
+typedef void (*MihomoStructuredLogCallback)(int level, const char* message, long long timestampMs, void* ctx);
+typedef void (*MihomoDelayCallback)(const char* name, int delay, const char* error, void* ctx);
+typedef void (*MihomoProxyHealthCallback)(const char* name, int delay, int alive, void* ctx);
+typedef void (*MihomoDNSCallback)(const char* result, void* ctx);
+typedef void (*MihomoUpdateCallback)(const char* name, long long bytes, const char* error, int done, void* ctx);
+typedef void (*MihomoConnectionCallback)(const char* event, void* ctx);
+
+static inline void mihomo_invoke_traffic(MihomoTrafficCallback cb, uint64_t timestamp_ms, uint64_t up, uint64_t down, void* ctx) {
+	MihomoTrafficSample sample = { timestamp_ms, up, down };
+	if (cb) cb(&sample, ctx);
+}
+
+static inline void mihomo_invoke_memory(MihomoMemoryCallback cb, uint64_t timestamp_ms, uint64_t inuse, uint64_t limit, void* ctx) {
+	MihomoMemorySample sample = { timestamp_ms, inuse, limit };
+	if (cb) cb(&sample, ctx);
+}
+
+static inline void mihomo_invoke_state(MihomoStateChangeCallback cb, MihomoCoreState state, void* ctx) {
+	if (cb) cb(state, ctx);
+}
+
+static inline void mihomo_invoke_mode(MihomoModeChangeCallback cb, const char* mode, void* ctx) {
+	if (cb) cb(mode, ctx);
+}
+
+static inline void mihomo_invoke_log(MihomoLogCallback cb, const char* line, void* ctx) {
+	if (cb) cb(line, ctx);
+}
+
+static inline void mihomo_invoke_structured_log(MihomoStructuredLogCallback cb, int level, const char* message, long long timestampMs, void* ctx) {
+	if (cb) cb(level, message, timestampMs, ctx);
+}
+
+static inline void mihomo_invoke_delay(MihomoDelayCallback cb, const char* name, int delay, const char* error, void* ctx) {
+	if (cb) cb(name, delay, error, ctx);
+}
+
+static inline void mihomo_invoke_proxy_health(MihomoProxyHealthCallback cb, const char* name, int delay, int alive, void* ctx) {
+	if (cb) cb(name, delay, alive, ctx);
+}
+
+static inline void mihomo_invoke_dns(MihomoDNSCallback cb, const char* result, void* ctx) {
+	if (cb) cb(result, ctx);
+}
+
+static inline void mihomo_invoke_update(MihomoUpdateCallback cb, const char* name, long long bytes, const char* error, int done, void* ctx) {
+	if (cb) cb(name, bytes, error, done, ctx);
+}
+
+static inline void mihomo_invoke_connection(MihomoConnectionCallback cb, const char* event, void* ctx) {
+	if (cb) cb(event, ctx);
+}
+
+#endif
@@ -0,0 +1,565 @@
+package main
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/metacubex/mihomo/component/dialer"
+	"github.com/metacubex/mihomo/component/process"
+	"github.com/metacubex/mihomo/component/resolver"
+	"github.com/metacubex/mihomo/config"
+	"github.com/metacubex/mihomo/hub/executor"
+	"github.com/metacubex/mihomo/listener"
+	"github.com/metacubex/mihomo/log"
+	"github.com/metacubex/mihomo/tunnel"
+)
+
+var yamlPosition = regexp.MustCompile(`line (\d+)(?:, column (\d+))?`)
+
+type parseError struct {
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Message string `json:"message"`
+}
+
+// newParseError pulls the position out of a yaml.v3 style message such as
+// "yaml: line 4: did not find expected key". Semantic errors raised after
+// decoding carry no position and report line 0.
+func newParseError(err error) parseError {
+	pe := parseError{Message: err.Error()}
+	if m := yamlPosition.FindStringSubmatch(pe.Message); m != nil {
+		pe.Line, _ = strconv.Atoi(m[1])
+		if m[2] != "" {
+			pe.Column, _ = strconv.Atoi(m[2])
+		}
+	}
+	return pe
+}
+
+// MihomoValidateConfig parses the config at cPath, or the current config when
+// cPath is empty, without applying it. It returns "" on success and a JSON
+// parseError otherwise. Only the read lock is taken so a running core is left
+// untouched.
+//
+//export MihomoValidateConfig
+func MihomoValidateConfig(cPath *C.char) *C.char {
+	release, _ := seize(false, false)
+	defer release()
+
+	path := ""
+	if cPath != nil {
+		path = absPath(C.GoString(cPath))
+	}
+
+	var err error
+	if path != "" {
+		_, err = executor.ParseWithPath(path)
+	} else {
+		_, err = parseCurrentConfig()
+	}
+	if err != nil {
+		data, _ := json.Marshal(newParseError(err))
+		return newCString(string(data))
+	}
+	return newCString("")
+}
+
+// patchSchema lists the general fields that can be changed on a running core
+// without rebuilding proxies or rules. Anything else needs a full restart.
+type patchSchema struct {
+	Port            *int                     `json:"port"`
+	SocksPort       *int                     `json:"socks-port"`
+	RedirPort       *int                     `json:"redir-port"`
+	TProxyPort      *int                     `json:"tproxy-port"`
+	MixedPort       *int                     `json:"mixed-port"`
+	AllowLan        *bool                    `json:"allow-lan"`
+	BindAddress     *string                  `json:"bind-address"`
+	Mode            *tunnel.TunnelMode       `json:"mode"`
+	LogLevel        *log.LogLevel            `json:"log-level"`
+	IPv6            *bool                    `json:"ipv6"`
+	Sniffing        *bool                    `json:"sniffing"`
+	TCPConcurrent   *bool                    `json:"tcp-concurrent"`
+	FindProcessMode *process.FindProcessMode `json:"find-process-mode"`
+	InterfaceName   *string                  `json:"interface-name"`
+}
+
+var hotReloadable = map[string]bool{
+	"port":              true,
+	"socks-port":        true,
+	"redir-port":        true,
+	"tproxy-port":       true,
+	"mixed-port":        true,
+	"allow-lan":         true,
+	"bind-address":      true,
+	"mode":              true,
+	"log-level":         true,
+	"ipv6":              true,
+	"sniffing":          true,
+	"tcp-concurrent":    true,
+	"find-process-mode": true,
+	"interface-name":    true,
+}
+
+func pointerOrDefault[T any](p *T, def T) T {
+	if p != nil {
+		return *p
+	}
+	return def
+}
+
+// applyPatch changes the running core in place. Listeners are only recreated
+// when a field that affects their address is part of the patch, so switching
+// mode or log level leaves every inbound and connection alone.
+func applyPatch(patch *patchSchema) {
+	if patch.AllowLan != nil {
+		listener.SetAllowLan(*patch.AllowLan)
+	}
+	if patch.BindAddress != nil {
+		listener.SetBindAddress(*patch.BindAddress)
+	}
+	if patch.Sniffing != nil {
+		tunnel.SetSniffing(*patch.Sniffing)
+	}
+	if patch.TCPConcurrent != nil {
+		dialer.SetTcpConcurrent(*patch.TCPConcurrent)
+	}
+	if patch.InterfaceName != nil {
+		dialer.DefaultInterface.Store(*patch.InterfaceName)
+	}
+
+	rebind := patch.AllowLan != nil || patch.BindAddress != nil
+	ports := listener.GetPorts()
+	if rebind || patch.Port != nil {
+		listener.ReCreateHTTP(pointerOrDefault(patch.Port, ports.Port), tunnel.Tunnel)
+	}
+	if rebind || patch.SocksPort != nil {
+		listener.ReCreateSocks(pointerOrDefault(patch.SocksPort, ports.SocksPort), tunnel.Tunnel)
+	}
+	if rebind || patch.RedirPort != nil {
+		listener.ReCreateRedir(pointerOrDefault(patch.RedirPort, ports.RedirPort), tunnel.Tunnel)
+	}
+	if rebind || patch.TProxyPort != nil {
+		listener.ReCreateTProxy(pointerOrDefault(patch.TProxyPort, ports.TProxyPort), tunnel.Tunnel)
+	}
+	if rebind || patch.MixedPort != nil {
+		listener.ReCreateMixed(pointerOrDefault(patch.MixedPort, ports.MixedPort), tunnel.Tunnel)
+	}
+
+	if patch.Mode != nil {
+		setMode(*patch.Mode)
+	}
+	if patch.FindProcessMode != nil {
+		tunnel.SetFindProcessMode(*patch.FindProcessMode)
+	}
+	if patch.LogLevel != nil {
+		setLogLevel(*patch.LogLevel)
+	}
+	if patch.IPv6 != nil {
+		resolver.DisableIPv6 = !*patch.IPv6
+	}
+}
+
+// runtimeGeneral is the part of the general config MihomoPatchConfig,
+// MihomoSetMode and MihomoSetLogLevel change without touching the file.
+type runtimeGeneral struct {
+	mode            tunnel.TunnelMode
+	logLevel        log.LogLevel
+	ipv6            bool
+	sniffing        bool
+	tcpConcurrent   bool
+	interfaceName   string
+	findProcessMode process.FindProcessMode
+}
+
+func captureGeneral() runtimeGeneral {
+	return runtimeGeneral{
+		mode:            tunnel.Mode(),
+		logLevel:        log.LogLevel(logLevel.Load()),
+		ipv6:            !resolver.DisableIPv6,
+		sniffing:        tunnel.IsSniffing(),
+		tcpConcurrent:   dialer.GetTcpConcurrent(),
+		interfaceName:   dialer.DefaultInterface.Load(),
+		findProcessMode: tunnel.FindProcessMode(),
+	}
+}
+
+// hold writes the runtime settings over cfg before a reload that is not a
+// restart, the way holdTun keeps the host's TUN device, so the reload only
+// picks up rules, proxies and providers from the file.
+func (g runtimeGeneral) hold(cfg *config.Config) {
+	cfg.General.Mode = g.mode
+	cfg.General.LogLevel = g.logLevel
+	cfg.General.IPv6 = g.ipv6
+	cfg.General.TCPConcurrent = g.tcpConcurrent
+	cfg.General.Interface = g.interfaceName
+	cfg.General.FindProcessMode = g.findProcessMode
+}
+
+// restore reapplies what a reload resets outside cfg.General: rebuilding
+// the sniffer switches sniffing back to the file's setting.
+func (g runtimeGeneral) restore() {
+	tunnel.SetSniffing(g.sniffing)
+}
+
+// MihomoPatchConfig applies a partial general config to the running core.
+// It returns ErrCodeNotStarted when stopped, ErrCodeInvalidConfig when the
+// JSON does not decode and ErrCodeNotHotReload when a key outside patchSchema
+// is present; in the last two cases nothing is applied. When a patched
+// listener fails to bind, the whole patch is undone and -1 is returned with
+// the port in the last error.
+//
+//export MihomoPatchConfig
+func MihomoPatchConfig(cJSON *C.char) C.int {
+	if cJSON == nil {
+		return ErrCodeInvalidConfig
+	}
+	data := []byte(C.GoString(cJSON))
+
+	release, err := seize(true, true)
+	if err != nil {
+		return errCode(err)
+	}
+	defer release()
+
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return fail(ErrCodeInvalidConfig, err)
+	}
+	for key := range keys {
+		if !hotReloadable[key] {
+			return fail(ErrCodeNotHotReload, fmt.Errorf("%s cannot be changed without a restart", key))
+		}
+	}
+
+	patch := &patchSchema{}
+	if err := json.Unmarshal(data, patch); err != nil {
+		return fail(ErrCodeInvalidConfig, err)
+	}
+
+	previous := currentPatch()
+	applyPatch(patch)
+	want := config.Inbound{
+		Port:        pointerOrDefault(patch.Port, *previous.Port),
+		SocksPort:   pointerOrDefault(patch.SocksPort, *previous.SocksPort),
+		RedirPort:   pointerOrDefault(patch.RedirPort, *previous.RedirPort),
+		TProxyPort:  pointerOrDefault(patch.TProxyPort, *previous.TProxyPort),
+		MixedPort:   pointerOrDefault(patch.MixedPort, *previous.MixedPort),
+		AllowLan:    pointerOrDefault(patch.AllowLan, *previous.AllowLan),
+		BindAddress: pointerOrDefault(patch.BindAddress, *previous.BindAddress),
+	}
+	if err := checkInbounds(want); err != nil {
+		applyPatch(previous)
+		return fail(-1, err)
+	}
+	return ErrCodeSuccess
+}
+
+// currentPatch captures every field of patchSchema as the running core has
+// it, so a patch that fails to bind can be undone in full.
+func currentPatch() *patchSchema {
+	ports := listener.GetPorts()
+	general := captureGeneral()
+	allowLan, bindAddress := listener.AllowLan(), listener.BindAddress()
+	return &patchSchema{
+		Port:            &ports.Port,
+		SocksPort:       &ports.SocksPort,
+		RedirPort:       &ports.RedirPort,
+		TProxyPort:      &ports.TProxyPort,
+		MixedPort:       &ports.MixedPort,
+		AllowLan:        &allowLan,
+		BindAddress:     &bindAddress,
+		Mode:            &general.mode,
+		LogLevel:        &general.logLevel,
+		IPv6:            &general.ipv6,
+		Sniffing:        &general.sniffing,
+		TCPConcurrent:   &general.tcpConcurrent,
+		FindProcessMode: &general.findProcessMode,
+		InterfaceName:   &general.interfaceName,
+	}
+}
+
+// MihomoSetMode switches the routing mode on the running tunnel and notifies
+// the mode listener. Unknown modes return -1.
+//
+//export MihomoSetMode
+func MihomoSetMode(cMode *C.char) C.int {
+	if cMode == nil {
+		return -1
+	}
+	mode, ok := tunnel.ModeMapping[strings.ToLower(C.GoString(cMode))]
+	if !ok {
+		return -1
+	}
+
+	release, err := seize(true, true)
+	if err != nil {
+		return errCode(err)
+	}
+	defer release()
+
+	setMode(mode)
+	return ErrCodeSuccess
+}
+
+//export MihomoGetMode
+func MihomoGetMode() *C.char {
+	release, _ := seize(false, false)
+	defer release()
+
+	return newCString(tunnel.Mode().String())
+}
+
+type boundPorts struct {
+	HTTP   int `json:"http"`
+	Socks  int `json:"socks"`
+	Mixed  int `json:"mixed"`
+	Redir  int `json:"redir"`
+	TProxy int `json:"tproxy"`
+	MITM   int `json:"mitm"`
+}
+
+// MihomoGetPorts returns the ports the live listeners are bound to.
+// mihomo treats a port of 0 as disabled rather than asking the system for a
+// free one, so there is no auto-assigned port to report: disabled and
+// inactive listeners both report 0. This core has no MITM inbound; the key
+// is kept so hosts can read the same shape from every build.
+//
+//export MihomoGetPorts
+func MihomoGetPorts() *C.char {
+	release, _ := seize(false, false)
+	defer release()
+
+	var bound boundPorts
+	if core.running {
+		ports := listener.GetPorts()
+		bound = boundPorts{
+			HTTP:   ports.Port,
+			Socks:  ports.SocksPort,
+			Mixed:  ports.MixedPort,
+			Redir:  ports.RedirPort,
+			TProxy: ports.TProxyPort,
+		}
+	}
+
+	data, _ := json.Marshal(bound)
+	return newCString(string(data))
+}
+
+// checkInbounds compares the ports want asks for with what the listeners
+// actually bound. mihomo logs a failed bind and carries on, so this is the
+// only way to notice one. The error names the first missing listener and,
+// where a fresh bind shows why, the OS error. It must run right after the
+// listeners are recreated, with gate held exclusively.
+func checkInbounds(want config.Inbound) error {
+	bound := listener.GetPorts()
+	inbounds := []struct {
+		kind       string
+		want, have int
+	}{
+		{"http", want.Port, bound.Port},
+		{"socks", want.SocksPort, bound.SocksPort},
+		{"mixed", want.MixedPort, bound.MixedPort},
+		{"redir", want.RedirPort, bound.RedirPort},
+		{"tproxy", want.TProxyPort, bound.TProxyPort},
+	}
+
+	for _, in := range inbounds {
+		if in.want == 0 || in.have != 0 {
+			continue
+		}
+		return fmt.Errorf("%s port %d: %w", in.kind, in.want, probeBind(want.BindAddress, in.want, want.AllowLan))
+	}
+	return nil
+}
+
+// inboundAddr builds a listener address the way mihomo does: loopback
+// unless the LAN is allowed, in which case "*" means every interface.
+func inboundAddr(host string, port int, allowLan bool) string {
+	if !allowLan {
+		return fmt.Sprintf("127.0.0.1:%d", port)
+	}
+	if host == "*" {
+		host = ""
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// probeBind retries a bind the way mihomo builds listener addresses to
+// recover the reason it failed, typically EADDRINUSE.
+func probeBind(host string, port int, allowLan bool) error {
+	addr := inboundAddr(host, port, allowLan)
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	_ = l.Close()
+	return fmt.Errorf("listener on %s did not start", addr)
+}
+
+// dialInbounds connects to every bound HTTP, SOCKS and mixed listener and
+// returns the first that refuses. Redir and TProxy are left out: a local
+// connection to them would be proxied back to itself.
+func dialInbounds(want config.Inbound) error {
+	bound := listener.GetPorts()
+	for _, port := range []int{bound.Port, bound.SocksPort, bound.MixedPort} {
+		if port == 0 {
+			continue
+		}
+		addr := inboundAddr(want.BindAddress, port, want.AllowLan)
+		if host, p, err := net.SplitHostPort(addr); err == nil && (host == "" || net.ParseIP(host).IsUnspecified()) {
+			addr = net.JoinHostPort("127.0.0.1", p)
+		}
+		conn, err := net.DialTimeout("tcp", addr, drainPollInterval)
+		if err != nil {
+			return err
+		}
+		_ = conn.Close()
+	}
+	return nil
+}
+
+type dnsSettings struct {
+	Enable      bool   `json:"enable"`
+	IPv6        bool   `json:"ipv6"`
+	FakeIP      bool   `json:"fakeIP"`
+	FakeIPRange string `json:"fakeIPRange,omitempty"`
+}
+
+type tunSettings struct {
+	Enable    bool   `json:"enable"`
+	Stack     string `json:"stack"`
+	Device    string `json:"device,omitempty"`
+	MTU       uint32 `json:"mtu,omitempty"`
+	AutoRoute bool   `json:"autoRoute"`
+}
+
+type liveConfig struct {
+	Ports           boundPorts  `json:"ports"`
+	Mode            string      `json:"mode"`
+	LogLevel        string      `json:"logLevel"`
+	AllowLan        bool        `json:"allowLan"`
+	BindAddress     string      `json:"bindAddress"`
+	IPv6            bool        `json:"ipv6"`
+	Sniffing        bool        `json:"sniffing"`
+	TCPConcurrent   bool        `json:"tcpConcurrent"`
+	FindProcessMode string      `json:"findProcessMode"`
+	InterfaceName   string      `json:"interfaceName"`
+	DNS             dnsSettings `json:"dns"`
+	Tun             tunSettings `json:"tun"`
+}
+
+// MihomoGetConfig returns the general settings the running core is using,
+// read back from mihomo's live state rather than the config file, so every
+// setter and patch is reflected. A stopped core yields "{}".
+//
+//export MihomoGetConfig
+func MihomoGetConfig() *C.char {
+	release, err := seize(false, true)
+	if err != nil {
+		return newCString("{}")
+	}
+	defer release()
+
+	general := executor.GetGeneral()
+	cfg := liveConfig{
+		Ports: boundPorts{
+			HTTP:   general.Port,
+			Socks:  general.SocksPort,
+			Mixed:  general.MixedPort,
+			Redir:  general.RedirPort,
+			TProxy: general.TProxyPort,
+		},
+		Mode:            general.Mode.String(),
+		LogLevel:        general.LogLevel.String(),
+		AllowLan:        general.AllowLan,
+		BindAddress:     general.BindAddress,
+		IPv6:            general.IPv6,
+		Sniffing:        tunnel.IsSniffing(),
+		TCPConcurrent:   general.TCPConcurrent,
+		FindProcessMode: general.FindProcessMode.String(),
+		InterfaceName:   general.Interface,
+		DNS: dnsSettings{
+			Enable: resolver.DefaultResolver != nil,
+			IPv6:   !resolver.DisableIPv6,
+			FakeIP: resolver.FakeIPEnabled(),
+		},
+		Tun: tunSettings{
+			Enable:    general.Tun.Enable,
+			Stack:     general.Tun.Stack.String(),
+			Device:    general.Tun.Device,
+			MTU:       general.Tun.MTU,
+			AutoRoute: general.Tun.AutoRoute,
+		},
+	}
+	if cfg.DNS.FakeIP {
+		if prefix := tunnel.FakeIPRange(); prefix.IsValid() {
+			cfg.DNS.FakeIPRange = prefix.String()
+		}
+	}
+
+	data, _ := json.Marshal(cfg)
+	return newCString(string(data))
+}
+
+// MihomoSetAllowLan rebinds every inbound listener to localhost only, or to
+// cBindAddr (all interfaces when empty or "*") when allow is set. If any
+// listener fails to bind, the previous binding is restored and -1 is
+// returned with the conflicting port in the last error.
+//
+//export MihomoSetAllowLan
+func MihomoSetAllowLan(allow C.int, cBindAddr *C.char) C.int {
+	bind := "*"
+	if cBindAddr != nil {
+		if b := C.GoString(cBindAddr); b != "" {
+			bind = b
+		}
+	}
+
+	release, err := seize(true, true)
+	if err != nil {
+		return errCode(err)
+	}
+	defer release()
+
+	ports := listener.GetPorts()
+	prevAllow, prevBind := listener.AllowLan(), listener.BindAddress()
+	want := config.Inbound{
+		Port:        ports.Port,
+		SocksPort:   ports.SocksPort,
+		RedirPort:   ports.RedirPort,
+		TProxyPort:  ports.TProxyPort,
+		MixedPort:   ports.MixedPort,
+		AllowLan:    allow != 0,
+		BindAddress: bind,
+	}
+
+	rebind := func(allowLan bool, bindAddress string) {
+		applyPatch(&patchSchema{
+			Port:        &want.Port,
+			SocksPort:   &want.SocksPort,
+			RedirPort:   &want.RedirPort,
+			TProxyPort:  &want.TProxyPort,
+			MixedPort:   &want.MixedPort,
+			AllowLan:    &allowLan,
+			BindAddress: &bindAddress,
+		})
+	}
+
+	// The ports are passed explicitly: after a failed bind the listener
+	// package reports those listeners as closed, with port 0.
+	rebind(want.AllowLan, want.BindAddress)
+	if err := checkInbounds(want); err != nil {
+		rebind(prevAllow, prevBind)
+		return fail(-1, err)
+	}
+	return ErrCodeSuccess
+}
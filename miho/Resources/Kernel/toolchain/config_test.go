@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/metacubex/mihomo/hub/executor"
+)
+
+func TestNewParseError(t *testing.T) {
+	for _, tt := range []struct {
+		yaml string
+		want parseError
+	}{
+		{"port: 1\nproxies: [\n", parseError{Line: 2, Message: "yaml: line 2: did not find expected node content"}},
+		{"port: 1\n  bad: x\n", parseError{Line: 2, Message: "yaml: line 2: mapping values are not allowed in this context"}},
+		{"mode: nonsense\n", parseError{Message: "invalid mode"}},
+	} {
+		_, err := executor.ParseWithBytes([]byte(tt.yaml))
+		if err == nil {
+			t.Fatalf("%q parsed without error", tt.yaml)
+		}
+		if got := newParseError(err); got != tt.want {
+			t.Errorf("newParseError(%q) = %+v; want %+v", err, got, tt.want)
+		}
+	}
+
+	got := newParseError(errors.New("yaml: line 7, column 3: did not find expected key"))
+	if got.Line != 7 || got.Column != 3 {
+		t.Errorf("newParseError with a column = %+v; want line 7, column 3", got)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.yaml")
+	invalid := filepath.Join(dir, "invalid.yaml")
+	if err := os.WriteFile(valid, []byte("mixed-port: 7890\nrules:\n  - MATCH,DIRECT\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(invalid, []byte("mixed-port: 7890\nrules: [\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if result := call("validateConfig", json.RawMessage(fmt.Sprintf(`{"path":%q}`, valid))); !result.OK {
+		t.Errorf("validateConfig(valid) = %+v; want ok", result)
+	}
+
+	result := call("validateConfig", json.RawMessage(fmt.Sprintf(`{"path":%q}`, invalid)))
+	var pe parseError
+	if data, ok := result.Data.(json.RawMessage); !ok || json.Unmarshal(data, &pe) != nil {
+		t.Fatalf("validateConfig(invalid) = %+v; want a parse error", result)
+	}
+	if result.OK || pe.Line != 2 {
+		t.Errorf("validateConfig(invalid) = %+v, %+v; want a failure on line 2", result, pe)
+	}
+}
+
+// freePort returns a loopback port nothing listens on.
+func freePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// startTestCore starts the core from yaml in a temporary home and stops it
+// when the test ends.
+func startTestCore(t *testing.T, yaml string) {
+	t.Helper()
+	params, _ := json.Marshal(map[string]string{"home": t.TempDir(), "yaml": yaml})
+	if result := call("startFromBytes", params); !result.OK {
+		t.Fatalf("startFromBytes = %+v", result)
+	}
+	t.Cleanup(func() { call("stop", nil) })
+}
+
+func boundMixedPort(t *testing.T) int {
+	t.Helper()
+	var ports boundPorts
+	if err := json.Unmarshal(call("getPorts", nil).Data.(json.RawMessage), &ports); err != nil {
+		t.Fatal(err)
+	}
+	return ports.Mixed
+}
+
+func TestHotReloadableMatchesPatchSchema(t *testing.T) {
+	schema := reflect.TypeOf(patchSchema{})
+	fields := make(map[string]bool, schema.NumField())
+	for i := 0; i < schema.NumField(); i++ {
+		fields[schema.Field(i).Tag.Get("json")] = true
+	}
+	if !reflect.DeepEqual(fields, hotReloadable) {
+		t.Errorf("patchSchema keys %v differ from hotReloadable %v", fields, hotReloadable)
+	}
+}
+
+func TestPatchConfig(t *testing.T) {
+	startTestCore(t, fmt.Sprintf("mixed-port: %d\nmode: rule\nrules:\n  - MATCH,DIRECT\n", freePort(t)))
+
+	patch := func(body string) callResult {
+		return call("patchConfig", json.RawMessage(body))
+	}
+	mode := func() any { return call("getMode", nil).Data }
+
+	if result := patch(`{"mode":"global","log-level":"warning"}`); !result.OK || mode() != "global" {
+		t.Fatalf("mode patch = %+v, mode %v; want global", result, mode())
+	}
+	if result := patch(`{"mode":"direct","external-controller":"127.0.0.1:9090"}`); result.Code != ErrCodeNotHotReload || mode() != "global" {
+		t.Errorf("patch with a restart-only key = %+v, mode %v; want %d and nothing applied", result, mode(), ErrCodeNotHotReload)
+	}
+	if result := patch(`{"mode":`); result.Code != ErrCodeInvalidConfig {
+		t.Errorf("malformed patch = %+v; want %d", result, ErrCodeInvalidConfig)
+	}
+
+	moved := freePort(t)
+	if result := patch(fmt.Sprintf(`{"mixed-port":%d}`, moved)); !result.OK || boundMixedPort(t) != moved {
+		t.Fatalf("port patch = %+v, mixed %d; want %d", result, boundMixedPort(t), moved)
+	}
+
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	busy := taken.Addr().(*net.TCPAddr).Port
+	result := patch(fmt.Sprintf(`{"mixed-port":%d,"mode":"direct"}`, busy))
+	if result.OK || !strings.Contains(result.Error, fmt.Sprint(busy)) {
+		t.Errorf("patch onto a busy port = %+v; want a failure naming port %d", result, busy)
+	}
+	if boundMixedPort(t) != moved || mode() != "global" {
+		t.Errorf("failed patch left mixed %d, mode %v; want %d, global", boundMixedPort(t), mode(), moved)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+/*
+#include "capi.h"
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/metacubex/mihomo/tunnel/statistic"
+)
+
+type connectionInfo struct {
+	ID            string    `json:"id"`
+	Upload        int64     `json:"upload"`
+	Download      int64     `json:"download"`
+	UploadSpeed   int64     `json:"uploadSpeed"`
+	DownloadSpeed int64     `json:"downloadSpeed"`
+	Chains        []string  `json:"chains"`
+	Rule          string    `json:"rule"`
+	RulePayload   string    `json:"rulePayload"`
+	Network       string    `json:"network"`
+	Host          string    `json:"host"`
+	Source        string    `json:"source"`
+	Destination   string    `json:"destination"`
+	Start         time.Time `json:"start"`
+}
+
+// connectionSample is a connection's byte counts as of the previous
+// MihomoGetConnections call. The trackers belong to mihomo, so the samples
+// are kept here keyed by ID and dropped once a connection is gone.
+type connectionSample struct {
+	at               time.Time
+	upload, download int64
+}
+
+var (
+	sampleMu sync.Mutex
+	samples  = make(map[string]connectionSample)
+)
+
+// sampleSpeeds fills in each connection's rate in bytes per second since the
+// previous call. A connection seen for the first time reports 0. Callers
+// only share the read lock, so the samples have their own mutex.
+func sampleSpeeds(conns []connectionInfo, now time.Time) {
+	sampleMu.Lock()
+	defer sampleMu.Unlock()
+
+	next := make(map[string]connectionSample, len(conns))
+	for i := range conns {
+		c := &conns[i]
+		if prev, ok := samples[c.ID]; ok {
+			if elapsed := now.Sub(prev.at).Seconds(); elapsed > 0 {
+				c.UploadSpeed = int64(float64(c.Upload-prev.upload) / elapsed)
+				c.DownloadSpeed = int64(float64(c.Download-prev.download) / elapsed)
+			}
+		}
+		next[c.ID] = connectionSample{at: now, upload: c.Upload, download: c.Download}
+	}
+	samples = next
+}
+
+func newConnectionInfo(info *statistic.TrackerInfo) connectionInfo {
+	ci := connectionInfo{
+		ID:          info.UUID.String(),
+		Upload:      info.UploadTotal.Load(),
+		Download:    info.DownloadTotal.Load(),
+		Chains:      info.Chain,
+		Rule:        info.Rule,
+		RulePayload: info.RulePayload,
+		Start:       info.Start,
+	}
+	if ci.Chains == nil {
+		ci.Chains = []string{}
+	}
+	if m := info.Metadata; m != nil {
+		ci.Network = m.NetWork.String()
+		ci.Host = m.Host
+		ci.Source = m.SourceAddress()
+		ci.Destination = m.RemoteAddress()
+	}
+	return ci
+}
+
+// MihomoGetConnections returns the live connections as a JSON array, with
+// upload and download rates measured since the previous call. A stopped core
+// yields "[]" so bindings never have to handle null.
+//
+//export MihomoGetConnections
+func MihomoGetConnections() *C.char {
+	release, _ := seize(false, false)
+	defer release()
+
+	if !core.running {
+		return newCString("[]")
+	}
+
+	snapshot := statistic.DefaultManager.Snapshot()
+	conns := make([]connectionInfo, 0, len(snapshot.Connections))
+	for _, info := range snapshot.Connections {
+		conns = append(conns, newConnectionInfo(info))
+	}
+	sampleSpeeds(conns, time.Now())
+
+	data, err := json.Marshal(conns)
+	if err != nil {
+		return newCString("[]")
+	}
+	return newCString(string(data))
+}
+
+// MihomoCloseConnection closes the tracked connection with the given ID and
+// returns 0, or -1 when no such connection is live.
+//
+//export MihomoCloseConnection
+func MihomoCloseConnection(cID *C.char) C.int {
+	if cID == nil {
+		return -1
+	}
+	id := C.GoString(cID)
+
+	release, _ := seize(false, false)
+	defer release()
+
+	c := statistic.DefaultManager.Get(id)
+	if c == nil {
+		return -1
+	}
+	_ = c.Close()
+	return 0
+}
+
+//export MihomoCloseAllConnections
+func MihomoCloseAllConnections() C.int {
+	release, _ := seize(false, false)
+	defer release()
+
+	return C.int(closeAllConnections())
+}
+
+func closeAllConnections() int {
+	closed := 0
+	statistic.DefaultManager.Range(func(c statistic.Tracker) bool {
+		_ = c.Close()
+		closed++
+		return true
+	})
+	return closed
+}
+
+type connectionEvent struct {
+	Event string `json:"event"`
+	connectionInfo
+}
+
+const connectionQueueSize = 4096
+
+var (
+	connectionPump  sync.Once
+	watching        atomic.Bool
+	connectionQueue = make(chan connectionEvent, connectionQueueSize)
+)
+
+func init() {
+	statistic.OnJoin = func(c statistic.Tracker) { queueConnection("open", c) }
+	statistic.OnLeave = func(c statistic.Tracker) { queueConnection("close", c) }
+}
+
+// queueConnection runs on the tracker hooks, which fire on the connection's
+// goroutine and from closes issued while gate is held, so it never waits:
+// events go into a local queue and are dropped when it is full.
+func queueConnection(event string, c statistic.Tracker) {
+	if !watching.Load() {
+		return
+	}
+	select {
+	case connectionQueue <- connectionEvent{Event: event, connectionInfo: newConnectionInfo(c.Info())}:
+	default:
+	}
+}
+
+// startConnectionPump delivers queued connection events in order once a
+// callback has been registered.
+func startConnectionPump() {
+	connectionPump.Do(func() {
+		watching.Store(true)
+		go func() {
+			for event := range connectionQueue {
+				emitConnection(event)
+			}
+		}()
+	})
+}
+
+func emitConnection(event connectionEvent) {
+	gate.RLock()
+	defer gate.RUnlock()
+
+	if core.connectionCb == nil {
+		return
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+
+	cEvent := C.CString(string(data))
+	C.mihomo_invoke_connection(core.connectionCb, cEvent, core.connectionCtx)
+	C.free(unsafe.Pointer(cEvent))
+}
+
+// MihomoSetConnectionCallback registers a callback fired with a JSON event
+// whenever the statistic manager starts or stops tracking a connection.
+// Events carry the same fields as MihomoGetConnections plus an "event" of
+// "open" or "close"; a close carries the final byte counts. Connections
+// already open when the callback is set are not reported opened, so a close
+// can arrive for an ID the host has not seen. Events are queued and
+// delivered in order; if the host falls more than 4096 events behind, the
+// excess is dropped.
+//
+//export MihomoSetConnectionCallback
+func MihomoSetConnectionCallback(cb C.MihomoConnectionCallback, ctx unsafe.Pointer) C.int {
+	release, _ := seize(true, false)
+	defer release()
+
+	core.connectionCb = cb
+	core.connectionCtx = ctx
+	startConnectionPump()
+	return ErrCodeSuccess
+}
+
+//export MihomoClearConnectionCallback
+func MihomoClearConnectionCallback() {
+	release, _ := seize(true, false)
+	defer release()
+
+	core.connectionCb = nil
+	core.connectionCtx = nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSampleSpeeds(t *testing.T) {
+	samples = make(map[string]connectionSample)
+	start := time.Unix(1700000000, 0)
+
+	sample := func(at time.Time, conns ...connectionInfo) []connectionInfo {
+		sampleSpeeds(conns, at)
+		return conns
+	}
+
+	first := sample(start, connectionInfo{ID: "a", Upload: 1000, Download: 5000})
+	if first[0].UploadSpeed != 0 || first[0].DownloadSpeed != 0 {
+		t.Errorf("first sample = %+v; want no rate", first[0])
+	}
+
+	second := sample(start.Add(2*time.Second),
+		connectionInfo{ID: "a", Upload: 3000, Download: 9000},
+		connectionInfo{ID: "b", Upload: 100},
+	)
+	if second[0].UploadSpeed != 1000 || second[0].DownloadSpeed != 2000 {
+		t.Errorf("rate over 2s = %+v; want 1000 up, 2000 down", second[0])
+	}
+	if second[1].UploadSpeed != 0 {
+		t.Errorf("new connection = %+v; want no rate", second[1])
+	}
+
+	same := sample(start.Add(2*time.Second), connectionInfo{ID: "b", Upload: 500})
+	if same[0].UploadSpeed != 0 {
+		t.Errorf("sample with no time elapsed = %+v; want no rate", same[0])
+	}
+
+	back := sample(start.Add(3*time.Second), connectionInfo{ID: "a", Upload: 4000, Download: 9000})
+	if back[0].UploadSpeed != 0 {
+		t.Errorf("connection missing from the previous sample = %+v; want its history dropped", back[0])
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"time"
+
+	"github.com/metacubex/mihomo/adapter/inbound"
+	"github.com/metacubex/mihomo/hub/route"
+)
+
+const controllerCloseTries = 20
+
+// closeController stops the controller started through
+// MihomoSetExternalController, if any. mihomo closes the server on a
+// goroutine, so this waits briefly for the port to come free; otherwise a
+// start right after would find it still taken. The caller must hold gate
+// exclusively.
+func closeController() {
+	addr := core.controllerAddr
+	if addr == "" {
+		return
+	}
+	route.ReCreateServer(&route.Config{})
+	core.controllerAddr = ""
+
+	for i := 0; i < controllerCloseTries; i++ {
+		if l, err := inbound.Listen("tcp", addr); err == nil {
+			_ = l.Close()
+			return
+		}
+		time.Sleep(drainPollInterval)
+	}
+}
+
+// MihomoSetExternalController starts mihomo's RESTful controller on cAddr
+// with cSecret, restarts it when already running, or stops it when cAddr is
+// empty. The core never starts the controller configured in the file, so
+// this is the only way to run one, and stopping the core stops it too.
+// CORS allows every origin. The address is bound once up front, because
+// the controller itself starts asynchronously and only logs failures. It
+// returns -1 when that bind fails.
+//
+//export MihomoSetExternalController
+func MihomoSetExternalController(cAddr *C.char, cSecret *C.char) C.int {
+	addr, secret := "", ""
+	if cAddr != nil {
+		addr = C.GoString(cAddr)
+	}
+	if cSecret != nil {
+		secret = C.GoString(cSecret)
+	}
+
+	release, err := seize(true, true)
+	if err != nil {
+		return errCode(err)
+	}
+	defer release()
+
+	// A restart on the same address would collide with the running
+	// controller, which is still holding the port.
+	if addr != "" && addr != core.controllerAddr {
+		l, err := inbound.Listen("tcp", addr)
+		if err != nil {
+			return fail(-1, err)
+		}
+		_ = l.Close()
+	}
+
+	route.ReCreateServer(&route.Config{
+		Addr:   addr,
+		Secret: secret,
+		Cors: route.Cors{
+			AllowOrigins:        []string{"*"},
+			AllowPrivateNetwork: true,
+		},
+	})
+	core.controllerAddr = addr
+	return ErrCodeSuccess
+}
@@ -0,0 +1,681 @@
+package main
+
+/*
+#include "capi.h"
+*/
+import "C"
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/metacubex/mihomo/component/geodata"
+	"github.com/metacubex/mihomo/component/mmdb"
+	"github.com/metacubex/mihomo/component/profile/cachefile"
+	"github.com/metacubex/mihomo/config"
+	"github.com/metacubex/mihomo/constant"
+	"github.com/metacubex/mihomo/hub/executor"
+	"github.com/metacubex/mihomo/listener"
+	LC "github.com/metacubex/mihomo/listener/config"
+	"github.com/metacubex/mihomo/log"
+	"github.com/metacubex/mihomo/tunnel"
+	"github.com/metacubex/mihomo/tunnel/statistic"
+
+	"github.com/metacubex/bbolt"
+)
+
+const drainPollInterval = 50 * time.Millisecond
+
+var (
+	errNotInitialized = errors.New("core not initialized")
+	errNotRunning     = errors.New("core not running")
+	errRunning        = errors.New("core already running")
+	errInvalidPaths   = errors.New("invalid home directory")
+)
+
+// coreCtx is the process-wide state of the embedded core. mihomo keeps its
+// tunnel, listeners and resolver in package globals, so there is only ever
+// one of these; every field is guarded by gate.
+type coreCtx struct {
+	initialized bool
+	running     bool
+	paused      bool
+	started     bool
+	failed      bool
+	startedAt   time.Time
+	tun         *LC.Tun
+	override    *globalOverride
+	homeDir     string
+	configFile  string
+	configBytes []byte
+	lastError   string
+
+	trafficCb      C.MihomoTrafficCallback
+	trafficCtx     unsafe.Pointer
+	memoryCb       C.MihomoMemoryCallback
+	memoryCtx      unsafe.Pointer
+	stateChangeCb  C.MihomoStateChangeCallback
+	stateChangeCtx unsafe.Pointer
+	reportedState  C.MihomoCoreState
+	modeChangeCb   C.MihomoModeChangeCallback
+	modeChangeCtx  unsafe.Pointer
+	logCb          C.MihomoLogCallback
+	logCtx         unsafe.Pointer
+	structLogCb    C.MihomoStructuredLogCallback
+	structLogCtx   unsafe.Pointer
+	proxyHealthCb  C.MihomoProxyHealthCallback
+	proxyHealthCtx unsafe.Pointer
+	connectionCb   C.MihomoConnectionCallback
+	connectionCtx  unsafe.Pointer
+
+	delayCtx    context.Context
+	delayCancel context.CancelFunc
+	delayTests  *sync.WaitGroup
+
+	controllerAddr string
+	cachePath      string
+}
+
+var core = &coreCtx{reportedState: C.MIHOMO_STATE_IDLE}
+
+func (c *coreCtx) state() C.MihomoCoreState {
+	switch {
+	case c.paused:
+		return C.MIHOMO_STATE_PAUSED
+	case c.running:
+		return C.MIHOMO_STATE_RUNNING
+	case c.failed:
+		return C.MIHOMO_STATE_ERROR
+	case c.started:
+		return C.MIHOMO_STATE_STOPPED
+	default:
+		return C.MIHOMO_STATE_IDLE
+	}
+}
+
+// seize takes gate for the duration of a core operation and returns the
+// matching release. Exclusive callers get the write lock, everyone else
+// shares the read lock. With requireRunning the core must have been started;
+// on error nothing is held. Exclusive callers also clear the last error on
+// success and record it on failure.
+func seize(exclusive, requireRunning bool) (func(), error) {
+	release := gate.RUnlock
+	if exclusive {
+		gate.Lock()
+		release = gate.Unlock
+	} else {
+		gate.RLock()
+	}
+
+	var err error
+	if requireRunning {
+		if !core.initialized {
+			err = errNotInitialized
+		} else if !core.running {
+			err = errNotRunning
+		}
+	}
+
+	if exclusive {
+		core.lastError = ""
+		if err != nil {
+			core.lastError = err.Error()
+		}
+	}
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return release, nil
+}
+
+// fail records err as the last error and returns code. The caller must hold
+// gate exclusively.
+func fail(code C.int, err error) C.int {
+	core.lastError = err.Error()
+	return code
+}
+
+func errCode(err error) C.int {
+	switch {
+	case err == nil:
+		return ErrCodeSuccess
+	case errors.Is(err, errNotInitialized):
+		return ErrCodeNotInitialized
+	case errors.Is(err, errNotRunning):
+		return ErrCodeNotStarted
+	default:
+		return ErrCodeInternalError
+	}
+}
+
+func absPath(path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	currentDir, _ := os.Getwd()
+	return filepath.Join(currentDir, path)
+}
+
+// parseCurrentConfig parses the config the core was initialised with: the
+// in-memory copy handed to MihomoStartFromBytes if there is one, otherwise
+// the config file. The caller must hold gate.
+func parseCurrentConfig() (*config.Config, error) {
+	if core.configBytes != nil {
+		return executor.ParseWithBytes(core.configBytes)
+	}
+	return executor.ParseWithPath(core.configFile)
+}
+
+// resolvePaths turns the home and config arguments into absolute paths. An
+// empty config means config.yaml inside home.
+func resolvePaths(cHome *C.char, cConfig *C.char) (string, string, bool) {
+	if cHome == nil {
+		return "", "", false
+	}
+
+	home := absPath(C.GoString(cHome))
+	if home == "" {
+		return "", "", false
+	}
+
+	file := ""
+	if cConfig != nil {
+		file = absPath(C.GoString(cConfig))
+	}
+	if file == "" {
+		file = filepath.Join(home, "config.yaml")
+	}
+	return home, file, true
+}
+
+// pointCore sets the directories mihomo resolves every relative path
+// against and creates the initial config if needed. The core returns to
+// IDLE, which is reported when it was STOPPED or ERROR before. The caller
+// must hold gate exclusively.
+func pointCore(home, file string) C.int {
+	constant.SetHomeDir(home)
+	constant.SetConfig(file)
+	if err := config.Init(home); err != nil {
+		return fail(ErrCodeInternalError, err)
+	}
+
+	core.homeDir = home
+	core.configFile = file
+	core.configBytes = nil
+	core.initialized = true
+	core.started = false
+	core.failed = false
+	notifyState()
+	return ErrCodeSuccess
+}
+
+// selectionCachePath is where selections are persisted: the host's override
+// when one is set, otherwise cache.db in the home directory.
+func selectionCachePath() string {
+	if core.cachePath != "" {
+		return core.cachePath
+	}
+	return constant.Path.Cache()
+}
+
+// openSelectionCache points mihomo's cache file at path. The new database
+// is opened before the old one is closed, so on error the current cache
+// stays in place. With carry set, every entry of the old file that the new
+// one lacks is copied over first. mihomo's own goroutines use the database
+// without gate, so the caller must hold gate exclusively with the core
+// stopped.
+func openSelectionCache(path string, carry bool) error {
+	cache := cachefile.Cache()
+	if cache.DB != nil && cache.DB.Path() == path {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	db, err := bbolt.Open(path, 0o666, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return err
+	}
+	if carry && cache.DB != nil {
+		if err := copyCache(cache.DB, db); err != nil {
+			_ = db.Close()
+			return err
+		}
+	}
+	if cache.DB != nil {
+		_ = cache.DB.Close()
+	}
+	cache.DB = db
+	return nil
+}
+
+// copyCache copies every bucket of from into to, keeping the entries to
+// already has: selections, fake-ip mappings, ETags and subscription info.
+func copyCache(from, to *bbolt.DB) error {
+	return from.View(func(src *bbolt.Tx) error {
+		return to.Update(func(dst *bbolt.Tx) error {
+			return src.ForEach(func(name []byte, bucket *bbolt.Bucket) error {
+				target, err := dst.CreateBucketIfNotExists(name)
+				if err != nil {
+					return err
+				}
+				return bucket.ForEach(func(k, v []byte) error {
+					if v == nil || target.Get(k) != nil {
+						return nil
+					}
+					return target.Put(k, v)
+				})
+			})
+		})
+	})
+}
+
+// resetHomeCaches drops the state mihomo opened lazily from the previous
+// home directory. The selection cache is reopened at the new path and the
+// geo databases are reloaded on first use. Provider files need nothing here
+// since their paths are resolved against the home directory on every parse.
+func resetHomeCaches() {
+	if err := openSelectionCache(selectionCachePath(), false); err != nil {
+		log.Warnln("[CacheFile] can't open cache file: %s", err.Error())
+		cache := cachefile.Cache()
+		if cache.DB != nil {
+			_ = cache.DB.Close()
+			cache.DB = nil
+		}
+	}
+
+	mmdb.ReloadIP()
+	mmdb.ReloadASN()
+	geodata.ClearGeoIPCache()
+	geodata.ClearGeoSiteCache()
+}
+
+// MihomoSetSelectionCachePath moves mihomo's cache file to cPath, for hosts
+// whose home directory is not writable. A relative path is taken against
+// the home directory and an empty one goes back to its cache.db. Everything
+// cached so far, selections as well as fake-ip mappings, ETags and
+// subscription info, is copied over where the new file has no entry. Every
+// MihomoSelectProxy commits and syncs its write before returning, so a
+// choice survives a crash right after it; the config's
+// profile.store-selected still decides whether selections are kept at all.
+// The file can only be moved while the core is stopped, so call this before
+// MihomoStart; it returns ErrCodeAlreadyStarted while running and -1 when
+// the file cannot be opened, leaving the current cache in use.
+//
+//export MihomoSetSelectionCachePath
+func MihomoSetSelectionCachePath(cPath *C.char) C.int {
+	release, _ := seize(true, false)
+	defer release()
+
+	if core.running {
+		return fail(ErrCodeAlreadyStarted, errRunning)
+	}
+
+	path := ""
+	if cPath != nil {
+		if p := C.GoString(cPath); p != "" {
+			path = constant.Path.Resolve(p)
+		}
+	}
+
+	target := path
+	if target == "" {
+		target = constant.Path.Cache()
+	}
+	if err := openSelectionCache(target, true); err != nil {
+		return fail(-1, err)
+	}
+	core.cachePath = path
+	return ErrCodeSuccess
+}
+
+//export MihomoInit
+func MihomoInit(cHome *C.char, cConfig *C.char) C.int {
+	release, _ := seize(true, false)
+	defer release()
+
+	home, file, ok := resolvePaths(cHome, cConfig)
+	if !ok {
+		return fail(ErrCodeInvalidConfig, errInvalidPaths)
+	}
+	if core.running {
+		return fail(ErrCodeAlreadyStarted, errRunning)
+	}
+	return pointCore(home, file)
+}
+
+// MihomoReinit points a stopped core at a different home directory and
+// config without reloading the library. The selection cache and geo
+// databases opened from the old home are dropped so the next start reads
+// the new profile's copies. It returns -1 while the core is running.
+//
+//export MihomoReinit
+func MihomoReinit(cHome *C.char, cConfig *C.char) C.int {
+	release, _ := seize(true, false)
+	defer release()
+
+	home, file, ok := resolvePaths(cHome, cConfig)
+	if !ok {
+		return fail(ErrCodeInvalidConfig, errInvalidPaths)
+	}
+	if core.running {
+		return fail(-1, errRunning)
+	}
+
+	moved := core.initialized && home != core.homeDir
+	if code := pointCore(home, file); code != ErrCodeSuccess {
+		return code
+	}
+	if moved {
+		resetHomeCaches()
+	}
+	return ErrCodeSuccess
+}
+
+// MihomoGetLastError returns the message recorded by the most recent
+// failing call that took the write lock, or "" when that call succeeded.
+//
+//export MihomoGetLastError
+func MihomoGetLastError() *C.char {
+	release, _ := seize(false, false)
+	defer release()
+
+	return newCString(core.lastError)
+}
+
+type coreStatus struct {
+	State         int    `json:"state"`
+	Initialized   bool   `json:"initialized"`
+	Running       bool   `json:"running"`
+	Paused        bool   `json:"paused"`
+	Uptime        int64  `json:"uptime"`
+	StartTimeUnix int64  `json:"startTimeUnix"`
+	Connections   int    `json:"connections"`
+	Mode          string `json:"mode"`
+}
+
+// MihomoGetStatus is a one-call health snapshot for host watchdogs. uptime
+// is measured on the monotonic clock, so it stays correct if the wall clock
+// jumps; startTimeUnix is the wall-clock start for callers that want to
+// compute it themselves. Both are 0 while stopped.
+//
+//export MihomoGetStatus
+func MihomoGetStatus() *C.char {
+	release, _ := seize(false, false)
+	defer release()
+
+	status := coreStatus{
+		State:       int(core.state()),
+		Initialized: core.initialized,
+		Running:     core.running,
+		Paused:      core.paused,
+		Mode:        tunnel.Mode().String(),
+	}
+	if core.running {
+		status.Uptime = int64(time.Since(core.startedAt) / time.Second)
+		status.StartTimeUnix = core.startedAt.Unix()
+		status.Connections = openConnections()
+	}
+
+	data, _ := json.Marshal(status)
+	return newCString(string(data))
+}
+
+//export MihomoStart
+func MihomoStart() C.int {
+	release, _ := seize(true, false)
+	defer release()
+
+	if !core.initialized {
+		return fail(ErrCodeNotInitialized, errNotInitialized)
+	}
+	if core.running {
+		return fail(ErrCodeAlreadyStarted, errRunning)
+	}
+	return startCore()
+}
+
+// MihomoStartAndWait starts the core like MihomoStart and returns 0 only
+// once every HTTP, SOCKS and mixed listener accepts a local connection, so
+// the host can connect straight away. A bind failure rolls back as in
+// MihomoStart. mihomo's listeners offer no readiness signal, so the wait
+// dials them, without holding gate so other calls and the emitters carry
+// on. When they are still not accepting after timeoutMs the core is
+// stopped again and -1 is returned, unless it was stopped or restarted in
+// the meantime.
+//
+//export MihomoStartAndWait
+func MihomoStartAndWait(timeoutMs C.int) C.int {
+	release, _ := seize(true, false)
+	var code C.int
+	switch {
+	case !core.initialized:
+		code = fail(ErrCodeNotInitialized, errNotInitialized)
+	case core.running:
+		code = fail(ErrCodeAlreadyStarted, errRunning)
+	default:
+		code = startCore()
+	}
+	if code != ErrCodeSuccess {
+		release()
+		return code
+	}
+	inbound := executor.GetGeneral().Inbound
+	startedAt := core.startedAt
+	release()
+
+	deadline := time.Now().Add(time.Duration(timeoutMs) * time.Millisecond)
+	err := dialInbounds(inbound)
+	for err != nil && time.Now().Before(deadline) {
+		time.Sleep(drainPollInterval)
+		err = dialInbounds(inbound)
+	}
+	if err == nil {
+		return ErrCodeSuccess
+	}
+
+	release, _ = seize(true, false)
+	defer release()
+
+	if core.running && core.startedAt.Equal(startedAt) {
+		core.failed = true
+		stopCore()
+		closeAllConnections()
+	}
+	return fail(-1, fmt.Errorf("listeners not ready after %dms: %w", int(timeoutMs), err))
+}
+
+// MihomoStartFromBytes starts the core from a YAML buffer that is kept in
+// memory and never written to disk; reloads parse the same copy. cHome is
+// still the directory provider files and caches resolve against. A later
+// MihomoInit or MihomoReinit goes back to reading the config file.
+//
+//export MihomoStartFromBytes
+func MihomoStartFromBytes(cHome *C.char, cYAML *C.char, length C.int) C.int {
+	release, _ := seize(true, false)
+	defer release()
+
+	home, file, ok := resolvePaths(cHome, nil)
+	if !ok {
+		return fail(ErrCodeInvalidConfig, errInvalidPaths)
+	}
+	if cYAML == nil || length <= 0 {
+		return fail(ErrCodeInvalidConfig, errors.New("empty config"))
+	}
+	if core.running {
+		return fail(ErrCodeAlreadyStarted, errRunning)
+	}
+
+	// config.Init would write a default config file, so only the
+	// directory is created here.
+	if err := os.MkdirAll(home, 0o755); err != nil {
+		return fail(ErrCodeInternalError, err)
+	}
+	moved := core.initialized && home != core.homeDir
+	constant.SetHomeDir(home)
+	constant.SetConfig(file)
+	core.homeDir = home
+	core.configFile = file
+	core.configBytes = C.GoBytes(unsafe.Pointer(cYAML), length)
+	core.initialized = true
+	if moved {
+		resetHomeCaches()
+	}
+	return startCore()
+}
+
+// startCore parses and applies the current config. The start is all or
+// nothing: if any configured inbound failed to bind, every listener that
+// did bind is closed again, the core stays stopped and the last error
+// names the port. The caller must hold gate exclusively and have checked
+// that the core is initialised and stopped.
+func startCore() C.int {
+	core.failed = false
+	emitState(C.MIHOMO_STATE_STARTING)
+
+	cfg, err := parseCurrentConfig()
+	if err != nil {
+		core.failed = true
+		notifyState()
+		return fail(ErrCodeInvalidConfig, err)
+	}
+
+	statistic.DefaultManager.ResetStatistic()
+	resetErrorStats()
+	executor.ApplyConfig(cfg, true)
+	if err := checkInbounds(cfg.General.Inbound); err != nil {
+		closeListeners()
+		closeAllConnections()
+		core.failed = true
+		notifyState()
+		return fail(ErrCodeInternalError, err)
+	}
+	setLogLevel(cfg.General.LogLevel)
+	core.running = true
+	core.started = true
+	core.startedAt = time.Now()
+	notifyState()
+	return ErrCodeSuccess
+}
+
+// closeListeners unbinds everything the core listens on. executor.Shutdown
+// only closes TUN, so each inbound is recreated with port 0 or an empty
+// config, which closes it, and the tunnel is suspended so connections the
+// listeners already accepted are dropped before they are routed. Recreating
+// TUN with an empty config also resets the cached TUN config, so the next
+// start opens it again. The caller must hold gate exclusively.
+func closeListeners() {
+	tunnel.OnSuspend()
+
+	listener.ReCreateHTTP(0, tunnel.Tunnel)
+	listener.ReCreateSocks(0, tunnel.Tunnel)
+	listener.ReCreateRedir(0, tunnel.Tunnel)
+	listener.ReCreateTProxy(0, tunnel.Tunnel)
+	listener.ReCreateMixed(0, tunnel.Tunnel)
+	listener.ReCreateShadowSocks("", tunnel.Tunnel)
+	listener.ReCreateVmess("", tunnel.Tunnel)
+	listener.ReCreateTuic(LC.TuicServer{}, tunnel.Tunnel)
+	listener.PatchInboundListeners(nil, tunnel.Tunnel, true)
+	listener.PatchTunnel(nil, tunnel.Tunnel)
+	listener.ReCreateTun(LC.Tun{}, tunnel.Tunnel)
+
+	executor.Shutdown()
+}
+
+// stopCore closes every listener and the external controller and resets
+// the per-run state, reporting STOPPING before and STOPPED after. Open
+// connections are left to the caller, which either closes them or drains
+// them. The caller must hold gate exclusively and have checked that the
+// core is running.
+func stopCore() {
+	emitState(C.MIHOMO_STATE_STOPPING)
+	closeListeners()
+	closeController()
+	core.running = false
+	core.paused = false
+	core.tun = nil
+	core.override = nil
+	core.startedAt = time.Time{}
+	notifyState()
+}
+
+//export MihomoStop
+func MihomoStop() C.int {
+	release, err := seize(true, true)
+	if err != nil {
+		return errCode(err)
+	}
+	defer release()
+
+	stopCore()
+	closeAllConnections()
+	return ErrCodeSuccess
+}
+
+// MihomoStopWithTimeout closes the listeners so no new connections arrive,
+// gives the connections still open up to timeoutMs to finish, then closes
+// whatever is left and returns how many were forced. gate is only held
+// while the listeners are torn down; the drain runs unlocked and only ever
+// touches the connections open at the stop, so a start issued meanwhile
+// keeps its own. The tunnel stays suspended throughout, so a connection a
+// listener accepted just before it closed is dropped rather than joining
+// the drain.
+//
+//export MihomoStopWithTimeout
+func MihomoStopWithTimeout(timeoutMs C.int) C.int {
+	release, err := seize(true, true)
+	if err != nil {
+		return errCode(err)
+	}
+	stopCore()
+	draining := trackedConnections()
+	release()
+
+	deadline := time.Now().Add(time.Duration(timeoutMs) * time.Millisecond)
+	for time.Now().Before(deadline) {
+		draining = stillOpen(draining)
+		if len(draining) == 0 {
+			break
+		}
+		time.Sleep(drainPollInterval)
+	}
+
+	forced := 0
+	for _, c := range stillOpen(draining) {
+		_ = c.Close()
+		forced++
+	}
+	return C.int(forced)
+}
+
+func trackedConnections() []statistic.Tracker {
+	var tracked []statistic.Tracker
+	statistic.DefaultManager.Range(func(c statistic.Tracker) bool {
+		tracked = append(tracked, c)
+		return true
+	})
+	return tracked
+}
+
+// stillOpen filters trackers down to those the manager still holds.
+func stillOpen(trackers []statistic.Tracker) []statistic.Tracker {
+	open := trackers[:0]
+	for _, c := range trackers {
+		if statistic.DefaultManager.Get(c.ID()) != nil {
+			open = append(open, c)
+		}
+	}
+	return open
+}
+
+func openConnections() int {
+	return len(trackedConnections())
+}
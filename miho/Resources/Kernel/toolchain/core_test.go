@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestStartRollsBackOnBusyInbound(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	busy := taken.Addr().(*net.TCPAddr).Port
+	mixed := freePort(t)
+
+	yaml := fmt.Sprintf("mixed-port: %d\nsocks-port: %d\nrules:\n  - MATCH,DIRECT\n", mixed, busy)
+	params, _ := json.Marshal(map[string]string{"home": t.TempDir(), "yaml": yaml})
+	result := call("startFromBytes", params)
+	if result.Code != ErrCodeInternalError || !strings.Contains(result.Error, fmt.Sprintf("socks port %d", busy)) {
+		t.Fatalf("start onto a busy socks port = %+v; want %d naming the port", result, ErrCodeInternalError)
+	}
+
+	var status coreStatus
+	if err := json.Unmarshal(call("getStatus", nil).Data.(json.RawMessage), &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Running {
+		t.Error("core reports running after a rolled back start")
+	}
+	if conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", mixed)); err == nil {
+		conn.Close()
+		t.Errorf("mixed port %d still accepts after the rollback", mixed)
+	}
+
+	taken.Close()
+	if result := call("start", nil); !result.OK {
+		t.Fatalf("start once the port is free = %+v", result)
+	}
+	call("stop", nil)
+}
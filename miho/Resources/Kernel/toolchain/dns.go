@@ -0,0 +1,207 @@
+package main
+
+/*
+#include "capi.h"
+*/
+import "C"
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/netip"
+	"strings"
+	"unsafe"
+
+	"github.com/metacubex/mihomo/component/resolver"
+	"github.com/metacubex/mihomo/tunnel"
+
+	D "github.com/miekg/dns"
+)
+
+var (
+	errDNSDisabled = errors.New("dns is disabled")
+	errDNSType     = errors.New("unknown record type")
+)
+
+type dnsRecord struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	TTL  uint32 `json:"ttl"`
+	Data string `json:"data"`
+}
+
+// dnsResult is delivered to MihomoDNSCallback. mihomo does not report which
+// upstream answered, so Source says whether the answer came from the fake-ip
+// pool or the resolver chain (hosts, nameserver-policy, nameservers).
+type dnsResult struct {
+	Domain  string      `json:"domain"`
+	Type    string      `json:"type"`
+	Rcode   string      `json:"rcode,omitempty"`
+	IPs     []string    `json:"ips,omitempty"`
+	Answers []dnsRecord `json:"answers,omitempty"`
+	Source  string      `json:"source,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+func emitDNS(cb C.MihomoDNSCallback, ctx unsafe.Pointer, result dnsResult) {
+	data, _ := json.Marshal(result)
+	cResult := C.CString(string(data))
+	defer C.free(unsafe.Pointer(cResult))
+
+	C.mihomo_invoke_dns(cb, cResult, ctx)
+}
+
+func queryDNS(domain string, qType uint16) dnsResult {
+	result := dnsResult{Domain: domain, Type: D.TypeToString[qType]}
+
+	ctx, cancel := context.WithTimeout(context.Background(), resolver.DefaultDNSTimeout)
+	defer cancel()
+
+	msg := &D.Msg{}
+	msg.SetQuestion(D.Fqdn(domain), qType)
+	resp, err := resolver.ServeMsg(ctx, msg)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Rcode = D.RcodeToString[resp.Rcode]
+	result.Source = "resolver"
+	for _, rr := range resp.Answer {
+		header := rr.Header()
+		result.Answers = append(result.Answers, dnsRecord{
+			Name: header.Name,
+			Type: D.TypeToString[header.Rrtype],
+			TTL:  header.Ttl,
+			Data: strings.TrimPrefix(rr.String(), header.String()),
+		})
+
+		var ip netip.Addr
+		switch record := rr.(type) {
+		case *D.A:
+			ip, _ = netip.AddrFromSlice(record.A.To4())
+		case *D.AAAA:
+			ip, _ = netip.AddrFromSlice(record.AAAA)
+		default:
+			continue
+		}
+		if resolver.IsFakeIP(ip) {
+			result.Source = "fake-ip"
+		}
+		result.IPs = append(result.IPs, ip.String())
+	}
+	return result
+}
+
+// MihomoQueryDNS resolves cDomain through the core's DNS service, the same
+// path the DNS listener uses, and hands the JSON dnsResult to cb from a
+// background goroutine. cType defaults to A. Failures are reported as a
+// result with Error set, never as a null string.
+//
+//export MihomoQueryDNS
+func MihomoQueryDNS(cDomain *C.char, cType *C.char, cb C.MihomoDNSCallback, ctx unsafe.Pointer) {
+	domain, typ := "", "A"
+	if cDomain != nil {
+		domain = C.GoString(cDomain)
+	}
+	if cType != nil {
+		if t := strings.ToUpper(C.GoString(cType)); t != "" {
+			typ = t
+		}
+	}
+
+	qType, ok := D.StringToType[typ]
+	if !ok {
+		emitDNS(cb, ctx, dnsResult{Domain: domain, Type: typ, Error: errDNSType.Error()})
+		return
+	}
+
+	release, err := seize(false, true)
+	if err != nil {
+		emitDNS(cb, ctx, dnsResult{Domain: domain, Type: typ, Error: err.Error()})
+		return
+	}
+	enabled := resolver.DefaultService != nil
+	release()
+
+	if !enabled {
+		emitDNS(cb, ctx, dnsResult{Domain: domain, Type: typ, Error: errDNSDisabled.Error()})
+		return
+	}
+
+	go func() {
+		emitDNS(cb, ctx, queryDNS(domain, qType))
+	}()
+}
+
+// MihomoFlushDNSCache drops the resolver cache and the fake-ip mappings so the
+// next lookup goes upstream; live connections keep the addresses they were
+// opened with. mihomo does not expose cache sizes, so the return value is the
+// number of stores flushed (the DNS cache and, when enabled, the fake-ip
+// pool) rather than an entry count.
+//
+//export MihomoFlushDNSCache
+func MihomoFlushDNSCache() C.int {
+	release, err := seize(false, true)
+	if err != nil {
+		return errCode(err)
+	}
+	defer release()
+
+	flushed := 0
+	if r := resolver.DefaultResolver; r != nil {
+		r.ClearCache()
+		flushed++
+	}
+	if resolver.FakeIPEnabled() {
+		if err := resolver.FlushFakeIP(); err != nil {
+			return ErrCodeInternalError
+		}
+		flushed++
+	}
+	return C.int(flushed)
+}
+
+// MihomoFakeIPToHost maps a fake IP back to the domain it was handed out
+// for, or returns "" when the address is not a live fake IP.
+//
+//export MihomoFakeIPToHost
+func MihomoFakeIPToHost(cIP *C.char) *C.char {
+	if cIP == nil {
+		return newCString("")
+	}
+	ip, err := netip.ParseAddr(C.GoString(cIP))
+	if err != nil {
+		return newCString("")
+	}
+
+	release, err := seize(false, true)
+	if err != nil {
+		return newCString("")
+	}
+	defer release()
+
+	if !resolver.IsFakeIP(ip.Unmap()) {
+		return newCString("")
+	}
+	host, _ := resolver.FindHostByIP(ip.Unmap())
+	return newCString(host)
+}
+
+// MihomoGetFakeIPRange returns the fake-ip CIDR from the running config, or
+// "" when the core is stopped.
+//
+//export MihomoGetFakeIPRange
+func MihomoGetFakeIPRange() *C.char {
+	release, err := seize(false, true)
+	if err != nil {
+		return newCString("")
+	}
+	defer release()
+
+	if prefix := tunnel.FakeIPRange(); prefix.IsValid() {
+		return newCString(prefix.String())
+	}
+	return newCString("")
+}
@@ -0,0 +1,131 @@
+package main
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/metacubex/mihomo/component/resolver"
+	"github.com/metacubex/mihomo/constant"
+	"github.com/metacubex/mihomo/tunnel"
+)
+
+// errorStats counts connection failures since the last start or reset. The
+// counters are fed from the hooks the kernel patches add to the tunnel's
+// failure sites.
+var errorStats struct {
+	since             atomic.Int64
+	dnsFailures       atomic.Int64
+	handshakeFailures atomic.Int64
+	dialTimeouts      atomic.Int64
+	dialFailures      atomic.Int64
+	ruleNoMatch       atomic.Int64
+}
+
+type errorCounts struct {
+	Since             int64 `json:"since"`
+	DNSFailures       int64 `json:"dnsFailures"`
+	HandshakeFailures int64 `json:"handshakeFailures"`
+	DialTimeouts      int64 `json:"dialTimeouts"`
+	DialFailures      int64 `json:"dialFailures"`
+	RuleNoMatch       int64 `json:"ruleNoMatch"`
+}
+
+func init() {
+	errorStats.since.Store(time.Now().UnixMilli())
+
+	tunnel.OnResolveError = func(*constant.Metadata, error) {
+		errorStats.dnsFailures.Add(1)
+	}
+	tunnel.OnDialError = countDialError
+	tunnel.OnNoMatch = func(*constant.Metadata) {
+		errorStats.ruleNoMatch.Add(1)
+	}
+}
+
+func resetErrorStats() {
+	errorStats.dnsFailures.Store(0)
+	errorStats.handshakeFailures.Store(0)
+	errorStats.dialTimeouts.Store(0)
+	errorStats.dialFailures.Store(0)
+	errorStats.ruleNoMatch.Store(0)
+	errorStats.since.Store(time.Now().UnixMilli())
+}
+
+func snapshotErrorStats() errorCounts {
+	return errorCounts{
+		Since:             errorStats.since.Load(),
+		DNSFailures:       errorStats.dnsFailures.Load(),
+		HandshakeFailures: errorStats.handshakeFailures.Load(),
+		DialTimeouts:      errorStats.dialTimeouts.Load(),
+		DialFailures:      errorStats.dialFailures.Load(),
+		RuleNoMatch:       errorStats.ruleNoMatch.Load(),
+	}
+}
+
+// countDialError classifies one failed dial attempt from the tunnel's hook.
+// It runs on the connection's goroutine, so it must never block. A failed
+// dial is a DNS failure when the target never resolved, a timeout when it
+// ran out of time, a plain dial failure when it went out through DIRECT,
+// such as a refused direct connection, and a proxy handshake failure
+// otherwise. mihomo retries a failing dial, so a connection that keeps
+// failing counts once per attempt.
+func countDialError(metadata *constant.Metadata, _ constant.Rule, proxy constant.ProxyAdapter, err error) {
+	switch {
+	case isResolveError(err):
+		errorStats.dnsFailures.Add(1)
+	case isTimeout(err):
+		errorStats.dialTimeouts.Add(1)
+	case dialedDirect(metadata, proxy):
+		errorStats.dialFailures.Add(1)
+	default:
+		errorStats.handshakeFailures.Add(1)
+	}
+}
+
+func isResolveError(err error) bool {
+	var resolveErr *resolver.ResolveError
+	var dnsErr *net.DNSError
+	return errors.As(err, &resolveErr) || errors.As(err, &dnsErr) || errors.Is(err, resolver.ErrIPNotFound)
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// dialedDirect reports whether proxy is DIRECT once groups are followed to
+// their current selection for metadata.
+func dialedDirect(metadata *constant.Metadata, proxy constant.ProxyAdapter) bool {
+	if proxy == nil {
+		return false
+	}
+	for next := proxy.Unwrap(metadata, false); next != nil; next = next.Unwrap(metadata, false) {
+		proxy = next
+	}
+	return proxy.Type() == constant.Direct
+}
+
+// MihomoGetErrorStats returns failure counters accumulated since the core
+// last started or MihomoResetErrorStats was called, with since as the Unix
+// millisecond timestamp the window opened. ruleNoMatch counts connections
+// that fell through every rule to the implicit DIRECT.
+//
+//export MihomoGetErrorStats
+func MihomoGetErrorStats() *C.char {
+	data, _ := json.Marshal(snapshotErrorStats())
+	return newCString(string(data))
+}
+
+//export MihomoResetErrorStats
+func MihomoResetErrorStats() {
+	resetErrorStats()
+}
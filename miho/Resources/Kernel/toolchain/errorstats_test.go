@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/metacubex/mihomo/adapter"
+	"github.com/metacubex/mihomo/adapter/outbound"
+	"github.com/metacubex/mihomo/adapter/outboundgroup"
+	mihomoProvider "github.com/metacubex/mihomo/adapter/provider"
+	"github.com/metacubex/mihomo/component/resolver"
+	"github.com/metacubex/mihomo/constant"
+	"github.com/metacubex/mihomo/constant/provider"
+)
+
+func newSelector(t *testing.T, name string, members []constant.Proxy, selected string) constant.Proxy {
+	t.Helper()
+	hc := mihomoProvider.NewHealthCheck(members, "", 0, 0, true, nil)
+	pd, err := mihomoProvider.NewCompatibleProvider(name, members, hc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	selector := outboundgroup.NewSelector(&outboundgroup.GroupCommonOption{Name: name}, []provider.ProxyProvider{pd})
+	if err := selector.Set(selected); err != nil {
+		t.Fatal(err)
+	}
+	return adapter.NewProxy(selector)
+}
+
+func TestCountDialError(t *testing.T) {
+	direct := adapter.NewProxy(outbound.NewDirect())
+	socks, err := outbound.NewSocks5(outbound.Socks5Option{Name: "my proxy", Server: "127.0.0.1", Port: 1080})
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := adapter.NewProxy(socks)
+	members := []constant.Proxy{direct, remote}
+	autoSelect := newSelector(t, "Auto Select", members, "DIRECT")
+	proxies := newSelector(t, "Proxies", members, "my proxy")
+
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+	cases := []struct {
+		name  string
+		proxy constant.Proxy
+		err   error
+		want  errorCounts
+	}{
+		{"direct refused", direct, refused, errorCounts{DialFailures: 1}},
+		{"group set to DIRECT refused", autoSelect, refused, errorCounts{DialFailures: 1}},
+		{"group set to a proxy refused", proxies, fmt.Errorf("connect to 127.0.0.1:1080: %w", refused), errorCounts{HandshakeFailures: 1}},
+		{"proxy handshake", remote, io.EOF, errorCounts{HandshakeFailures: 1}},
+		{"i/o timeout", proxies, &net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded}, errorCounts{DialTimeouts: 1}},
+		{"context deadline", direct, fmt.Errorf("connect failed: %w", context.DeadlineExceeded), errorCounts{DialTimeouts: 1}},
+		{"unresolved target", direct, fmt.Errorf("dns resolve failed: %w", &resolver.ResolveError{Err: errors.New("all DNS requests failed")}), errorCounts{DNSFailures: 1}},
+		{"resolve timeout", remote, fmt.Errorf("dns resolve failed: %w", &resolver.ResolveError{Err: context.DeadlineExceeded}), errorCounts{DNSFailures: 1}},
+		{"no address", direct, fmt.Errorf("%w: example.com", resolver.ErrIPNotFound), errorCounts{DNSFailures: 1}},
+	}
+
+	metadata := &constant.Metadata{NetWork: constant.TCP, Host: "example.com", DstPort: 443}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			resetErrorStats()
+			countDialError(metadata, nil, c.proxy, c.err)
+			got := snapshotErrorStats()
+			got.Since = 0
+			if got != c.want {
+				t.Errorf("%v counted %+v, want %+v", c.err, got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+/*
+#include "capi.h"
+*/
+import "C"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/metacubex/mihomo/component/geodata"
+	mihomoHttp "github.com/metacubex/mihomo/component/http"
+	"github.com/metacubex/mihomo/component/mmdb"
+	"github.com/metacubex/mihomo/constant"
+	"github.com/metacubex/mihomo/hub/executor"
+)
+
+const geoDownloadTimeout = 5 * time.Minute
+
+var (
+	errGeoUpdating = errors.New("update already in progress")
+	updatingGeo    atomic.Bool
+)
+
+func emitUpdate(cb C.MihomoUpdateCallback, ctx unsafe.Pointer, name string, bytes int64, err error, done bool) {
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+
+	var cErr *C.char
+	if err != nil {
+		cErr = C.CString(err.Error())
+		defer C.free(unsafe.Pointer(cErr))
+	}
+
+	cDone := C.int(0)
+	if done {
+		cDone = 1
+	}
+	C.mihomo_invoke_update(cb, cName, C.longlong(bytes), cErr, cDone, ctx)
+}
+
+// geoDatabase is one database file the core has been configured to use.
+// verify checks a downloaded temp file, unload releases the live file before
+// it is replaced and reload makes the matchers pick up the new one.
+type geoDatabase struct {
+	name   string
+	url    string
+	path   string
+	verify func(path string) error
+	unload func()
+	reload func()
+}
+
+func verifyMMDB(path string) error {
+	if !mmdb.Verify(path) {
+		return errors.New("invalid mmdb database")
+	}
+	return nil
+}
+
+func verifyGeoData(load func(loader geodata.Loader, data []byte) error) func(string) error {
+	return func(path string) error {
+		loader, err := geodata.GetGeoDataLoader("standard")
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return load(loader, data)
+	}
+}
+
+// geoDatabases lists the databases enabled by the running config, the same
+// selection mihomo's own updater makes.
+func geoDatabases() []geoDatabase {
+	var dbs []geoDatabase
+	if geodata.GeoIpEnable() {
+		if geodata.GeodataMode() {
+			dbs = append(dbs, geoDatabase{
+				name: "geoip",
+				url:  geodata.GeoIpUrl(),
+				path: constant.Path.GeoIP(),
+				verify: verifyGeoData(func(loader geodata.Loader, data []byte) error {
+					_, err := loader.LoadIPByBytes(data, "cn")
+					return err
+				}),
+				reload: geodata.ClearGeoIPCache,
+			})
+		} else {
+			dbs = append(dbs, geoDatabase{
+				name:   "mmdb",
+				url:    geodata.MmdbUrl(),
+				path:   constant.Path.MMDB(),
+				verify: verifyMMDB,
+				unload: func() { _ = mmdb.IPInstance().Reader.Close() },
+				reload: mmdb.ReloadIP,
+			})
+		}
+	}
+	if geodata.ASNEnable() {
+		dbs = append(dbs, geoDatabase{
+			name:   "asn",
+			url:    geodata.ASNUrl(),
+			path:   constant.Path.ASN(),
+			verify: verifyMMDB,
+			unload: func() { _ = mmdb.ASNInstance().Reader.Close() },
+			reload: mmdb.ReloadASN,
+		})
+	}
+	if geodata.GeoSiteEnable() {
+		dbs = append(dbs, geoDatabase{
+			name: "geosite",
+			url:  geodata.GeoSiteUrl(),
+			path: constant.Path.GeoSite(),
+			verify: verifyGeoData(func(loader geodata.Loader, data []byte) error {
+				_, err := loader.LoadSiteByBytes(data, "cn")
+				return err
+			}),
+			reload: geodata.ClearGeoSiteCache,
+		})
+	}
+	return dbs
+}
+
+type progressReader struct {
+	io.Reader
+	read     int64
+	progress func(int64)
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		r.read += int64(n)
+		r.progress(r.read)
+	}
+	return n, err
+}
+
+// download fetches db into a temp file next to its destination and verifies
+// it. The existing database is never touched; the caller renames the temp
+// file into place once every download has succeeded.
+func (db *geoDatabase) download(progress func(int64)) (string, int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), geoDownloadTimeout)
+	defer cancel()
+
+	resp, err := mihomoHttp.HttpRequest(ctx, db.url, http.MethodGet, nil, nil)
+	if err != nil {
+		return "", 0, fmt.Errorf("%s: %w", db.name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("%s: unexpected status %s", db.name, resp.Status)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(db.path), 0o755); err != nil {
+		return "", 0, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(db.path), filepath.Base(db.path)+".*.tmp")
+	if err != nil {
+		return "", 0, err
+	}
+
+	body := &progressReader{Reader: resp.Body, progress: progress}
+	_, err = io.Copy(tmp, body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = db.verify(tmp.Name())
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", 0, fmt.Errorf("%s: %w", db.name, err)
+	}
+	return tmp.Name(), body.read, nil
+}
+
+// swap renames the verified temp file over the live database. mmdb files are
+// memory mapped, so the open reader is closed first, as mihomo's updater does.
+func (db *geoDatabase) swap(tmp string) error {
+	if db.unload != nil {
+		if _, err := os.Stat(db.path); err == nil {
+			db.unload()
+		}
+	}
+	err := os.Rename(tmp, db.path)
+	db.reload()
+	return err
+}
+
+// reloadRules re-applies the current config without forcing the listeners so
+// rule matchers are rebuilt against the new databases. Everything changed at
+// runtime survives: the general overrides, the global proxy override, the
+// host's TUN device and a pause.
+func reloadRules() error {
+	release, err := seize(true, true)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	cfg, err := parseCurrentConfig()
+	if err != nil {
+		core.lastError = err.Error()
+		return err
+	}
+	general := captureGeneral()
+	general.hold(cfg)
+	holdTun(cfg)
+	executor.ApplyConfig(cfg, false)
+	general.restore()
+	holdGlobal()
+	holdPause()
+	return nil
+}
+
+// MihomoUpdateGeoDatabases downloads every configured geo database on a
+// background goroutine. cb receives the running byte count per database,
+// then a final call with an empty name and done set, carrying an error if
+// anything failed. A failed download leaves every existing file in place.
+// Only one update runs at a time; a concurrent call is rejected at once.
+//
+//export MihomoUpdateGeoDatabases
+func MihomoUpdateGeoDatabases(cb C.MihomoUpdateCallback, ctx unsafe.Pointer) {
+	if !updatingGeo.CompareAndSwap(false, true) {
+		emitUpdate(cb, ctx, "", 0, errGeoUpdating, true)
+		return
+	}
+
+	release, err := seize(false, true)
+	if err != nil {
+		updatingGeo.Store(false)
+		emitUpdate(cb, ctx, "", 0, err, true)
+		return
+	}
+	dbs := geoDatabases()
+	release()
+
+	go func() {
+		defer updatingGeo.Store(false)
+
+		var total int64
+		temps := make([]string, len(dbs))
+		cleanup := func() {
+			for _, tmp := range temps {
+				if tmp != "" {
+					_ = os.Remove(tmp)
+				}
+			}
+		}
+
+		for i := range dbs {
+			db := &dbs[i]
+			tmp, n, err := db.download(func(read int64) {
+				emitUpdate(cb, ctx, db.name, read, nil, false)
+			})
+			if err != nil {
+				cleanup()
+				emitUpdate(cb, ctx, "", total, err, true)
+				return
+			}
+			temps[i] = tmp
+			total += n
+		}
+
+		for i := range dbs {
+			if err := dbs[i].swap(temps[i]); err != nil {
+				cleanup()
+				emitUpdate(cb, ctx, "", total, err, true)
+				return
+			}
+			temps[i] = ""
+		}
+
+		emitUpdate(cb, ctx, "", total, reloadRules(), true)
+	}()
+}
@@ -0,0 +1,100 @@
+package main
+
+/*
+#include "capi.h"
+*/
+import "C"
+
+import (
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/metacubex/mihomo/tunnel"
+)
+
+const healthInterval = time.Second
+
+type proxyHealth struct {
+	delay uint16
+	alive bool
+}
+
+var healthPump sync.Once
+
+// startHealthWatcher compares every proxy's alive flag and last recorded
+// delay against the previous pass and reports the ones that changed. mihomo
+// has no hook for health-check results, so the watcher samples the state the
+// url-test groups and delay tests already maintain instead of testing
+// anything itself.
+func startHealthWatcher() {
+	healthPump.Do(func() {
+		go func() {
+			seen := make(map[string]proxyHealth)
+			ticker := time.NewTicker(healthInterval)
+			defer ticker.Stop()
+			for range ticker.C {
+				seen = emitHealth(seen)
+			}
+		}()
+	})
+}
+
+func emitHealth(seen map[string]proxyHealth) map[string]proxyHealth {
+	gate.RLock()
+	defer gate.RUnlock()
+
+	if !core.running {
+		return make(map[string]proxyHealth)
+	}
+
+	proxies := tunnel.ProxiesWithProviders()
+	current := make(map[string]proxyHealth, len(proxies))
+	for name, proxy := range proxies {
+		health := proxyHealth{alive: proxy.AliveForTestUrl("")}
+		if history := proxy.DelayHistory(); len(history) > 0 {
+			health.delay = history[len(history)-1].Delay
+		}
+		current[name] = health
+
+		if prev, ok := seen[name]; ok && prev == health {
+			continue
+		}
+		if core.proxyHealthCb == nil {
+			continue
+		}
+
+		cName := C.CString(name)
+		alive := C.int(0)
+		if health.alive {
+			alive = 1
+		}
+		C.mihomo_invoke_proxy_health(core.proxyHealthCb, cName, C.int(health.delay), alive, core.proxyHealthCtx)
+		C.free(unsafe.Pointer(cName))
+	}
+	return current
+}
+
+// MihomoSetProxyHealthCallback registers a callback fired with a proxy's
+// name, last delay in milliseconds and alive flag whenever either changes.
+// The first pass after a start reports every proxy.
+//
+//export MihomoSetProxyHealthCallback
+func MihomoSetProxyHealthCallback(cb C.MihomoProxyHealthCallback, ctx unsafe.Pointer) C.int {
+	release, _ := seize(true, false)
+	defer release()
+
+	core.proxyHealthCb = cb
+	core.proxyHealthCtx = ctx
+	startHealthWatcher()
+	return ErrCodeSuccess
+}
+
+//export MihomoClearProxyHealthCallback
+func MihomoClearProxyHealthCallback() {
+	release, _ := seize(true, false)
+	defer release()
+
+	core.proxyHealthCb = nil
+	core.proxyHealthCtx = nil
+}
@@ -0,0 +1,154 @@
+package main
+
+/*
+#include "capi.h"
+*/
+import "C"
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/metacubex/mihomo/log"
+)
+
+const logQueueSize = 1024
+
+var (
+	logLevel atomic.Int32
+	logPump  sync.Once
+)
+
+type logRecord struct {
+	event log.Event
+	at    time.Time
+}
+
+func init() {
+	logLevel.Store(int32(log.INFO))
+}
+
+// setLogLevel updates both the level mihomo prints at and the level the log
+// callback is fed at. SILENT filters every event, so the callback stops
+// firing altogether.
+func setLogLevel(level log.LogLevel) {
+	logLevel.Store(int32(level))
+	log.SetLevel(level)
+}
+
+// startLogPump subscribes to mihomo's log stream once per process. mihomo
+// blocks its logging call sites when a subscriber falls behind, and the
+// core logs while gate is held for writing, so events are moved into a
+// local queue without ever waiting on gate and dropped when it is full.
+func startLogPump() {
+	logPump.Do(func() {
+		sub := log.Subscribe()
+		queue := make(chan logRecord, logQueueSize)
+
+		go func() {
+			for event := range sub {
+				if int32(event.LogLevel) < logLevel.Load() {
+					continue
+				}
+				select {
+				case queue <- logRecord{event, time.Now()}:
+				default:
+				}
+			}
+			close(queue)
+		}()
+
+		go func() {
+			for record := range queue {
+				emitLog(record)
+			}
+		}()
+	})
+}
+
+func emitLog(record logRecord) {
+	gate.RLock()
+	defer gate.RUnlock()
+
+	event := record.event
+	if int32(event.LogLevel) < logLevel.Load() {
+		return
+	}
+
+	if core.logCb != nil {
+		line := C.CString(fmt.Sprintf("%s [%s] %s", record.at.Format(time.RFC3339Nano), event.Type(), event.Payload))
+		C.mihomo_invoke_log(core.logCb, line, core.logCtx)
+		C.free(unsafe.Pointer(line))
+	}
+
+	if core.structLogCb != nil {
+		message := C.CString(event.Payload)
+		C.mihomo_invoke_structured_log(core.structLogCb, C.int(event.LogLevel), message, C.longlong(record.at.UnixMilli()), core.structLogCtx)
+		C.free(unsafe.Pointer(message))
+	}
+}
+
+//export MihomoSetLogCallback
+func MihomoSetLogCallback(cb C.MihomoLogCallback, ctx unsafe.Pointer) C.int {
+	release, _ := seize(true, false)
+	defer release()
+
+	core.logCb = cb
+	core.logCtx = ctx
+	startLogPump()
+	return ErrCodeSuccess
+}
+
+//export MihomoClearLogCallback
+func MihomoClearLogCallback() {
+	release, _ := seize(true, false)
+	defer release()
+
+	core.logCb = nil
+	core.logCtx = nil
+}
+
+// MihomoSetLogLevel changes verbosity without a reload. It accepts debug,
+// info, warning, error and silent, and returns -1 for anything else.
+//
+//export MihomoSetLogLevel
+func MihomoSetLogLevel(cLevel *C.char) C.int {
+	if cLevel == nil {
+		return -1
+	}
+	level, ok := log.LogLevelMapping[strings.ToLower(C.GoString(cLevel))]
+	if !ok {
+		return -1
+	}
+
+	setLogLevel(level)
+	return ErrCodeSuccess
+}
+
+// MihomoSetStructuredLogCallback registers a callback that receives the level
+// as one of the MIHOMO_LOG_* values, the raw message and a Unix millisecond
+// timestamp. It can be set alongside the line-based log callback.
+//
+//export MihomoSetStructuredLogCallback
+func MihomoSetStructuredLogCallback(cb C.MihomoStructuredLogCallback, ctx unsafe.Pointer) C.int {
+	release, _ := seize(true, false)
+	defer release()
+
+	core.structLogCb = cb
+	core.structLogCtx = ctx
+	startLogPump()
+	return ErrCodeSuccess
+}
+
+//export MihomoClearStructuredLogCallback
+func MihomoClearStructuredLogCallback() {
+	release, _ := seize(true, false)
+	defer release()
+
+	core.structLogCb = nil
+	core.structLogCtx = nil
+}
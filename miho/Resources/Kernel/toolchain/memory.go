@@ -0,0 +1,76 @@
+package main
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"math"
+	"runtime"
+	"runtime/debug"
+)
+
+type gcResult struct {
+	HeapBefore uint64 `json:"heapBefore"`
+	HeapAfter  uint64 `json:"heapAfter"`
+	Freed      uint64 `json:"freed"`
+	Released   uint64 `json:"released"`
+}
+
+// MihomoForceGC runs a full collection and returns freed pages to the OS,
+// for hosts reacting to a low-memory warning. Heap figures are live heap
+// bytes; released is how much more the runtime handed back to the OS. It
+// touches no core state and takes no lock.
+//
+//export MihomoForceGC
+func MihomoForceGC() *C.char {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	runtime.GC()
+	debug.FreeOSMemory()
+	runtime.ReadMemStats(&after)
+
+	result := gcResult{
+		HeapBefore: before.HeapAlloc,
+		HeapAfter:  after.HeapAlloc,
+	}
+	if before.HeapAlloc > after.HeapAlloc {
+		result.Freed = before.HeapAlloc - after.HeapAlloc
+	}
+	if after.HeapReleased > before.HeapReleased {
+		result.Released = after.HeapReleased - before.HeapReleased
+	}
+
+	data, _ := json.Marshal(result)
+	return newCString(string(data))
+}
+
+// memoryLimit returns the soft limit set through MihomoSetMemoryLimit, or 0
+// when the runtime default of no limit is in effect.
+func memoryLimit() int64 {
+	if limit := debug.SetMemoryLimit(-1); limit != math.MaxInt64 {
+		return limit
+	}
+	return 0
+}
+
+// MihomoSetMemoryLimit sets the Go runtime's soft memory limit so it collects
+// more aggressively as the heap approaches it. It is a target, not a cap the
+// core refuses to exceed. 0 restores the default of no limit and negative
+// values return -1.
+//
+//export MihomoSetMemoryLimit
+func MihomoSetMemoryLimit(bytes C.longlong) C.int {
+	if bytes < 0 {
+		return -1
+	}
+
+	limit := int64(bytes)
+	if limit == 0 {
+		limit = math.MaxInt64
+	}
+	debug.SetMemoryLimit(limit)
+	return ErrCodeSuccess
+}
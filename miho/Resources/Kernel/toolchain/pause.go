@@ -0,0 +1,77 @@
+package main
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"github.com/metacubex/mihomo/tunnel"
+)
+
+// holdPause puts the tunnel back into suspend after executor.ApplyConfig,
+// which always finishes by marking it running. The caller must hold gate.
+func holdPause() {
+	if core.paused {
+		tunnel.OnSuspend()
+	}
+}
+
+// MihomoPause stops forwarding while every listener stays bound. The tunnel
+// is suspended, so new connections are still accepted by the listeners but
+// closed before they are routed; clients see a reset rather than a refused
+// port. mihomo cannot hold a connection, so new ones are always rejected
+// rather than queued. Existing connections are closed too, so nothing keeps
+// moving traffic on a metered link. With keepExisting set they are left
+// running instead, and a pause then does not stop in-flight traffic.
+// Pausing an already paused core is a no-op.
+//
+//export MihomoPause
+func MihomoPause(keepExisting C.int) C.int {
+	release, err := seize(true, true)
+	if err != nil {
+		return errCode(err)
+	}
+	defer release()
+
+	if core.paused {
+		return ErrCodeSuccess
+	}
+
+	tunnel.OnSuspend()
+	core.paused = true
+	if keepExisting == 0 {
+		closeAllConnections()
+	}
+	notifyState()
+	return ErrCodeSuccess
+}
+
+//export MihomoResume
+func MihomoResume() C.int {
+	release, err := seize(true, true)
+	if err != nil {
+		return errCode(err)
+	}
+	defer release()
+
+	if !core.paused {
+		return ErrCodeSuccess
+	}
+
+	tunnel.OnRunning()
+	core.paused = false
+	notifyState()
+	return ErrCodeSuccess
+}
+
+//export MihomoIsPaused
+func MihomoIsPaused() C.int {
+	release, _ := seize(false, false)
+	defer release()
+
+	if core.paused {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,323 @@
+package main
+
+/*
+#include "capi.h"
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+	"unsafe"
+
+	mihomoProvider "github.com/metacubex/mihomo/adapter/provider"
+	"github.com/metacubex/mihomo/component/profile/cachefile"
+	"github.com/metacubex/mihomo/constant/provider"
+	"github.com/metacubex/mihomo/tunnel"
+)
+
+var errProviderNotFound = errors.New("provider not found")
+
+// updatedAt reports when a provider last fetched its payload. Inline
+// providers have no fetcher and report the zero time.
+func updatedAt(p provider.Provider) time.Time {
+	if u, ok := p.(interface{ UpdatedAt() time.Time }); ok {
+		return u.UpdatedAt()
+	}
+	return time.Time{}
+}
+
+type ruleProviderInfo struct {
+	Name        string    `json:"name"`
+	Behavior    string    `json:"behavior"`
+	RuleCount   int       `json:"ruleCount"`
+	VehicleType string    `json:"vehicleType"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// MihomoGetRuleProviders returns every rule provider keyed by name. A stopped
+// core yields "{}".
+//
+//export MihomoGetRuleProviders
+func MihomoGetRuleProviders() *C.char {
+	release, err := seize(false, true)
+	if err != nil {
+		return newCString("{}")
+	}
+	defer release()
+
+	providers := tunnel.RuleProviders()
+	infos := make(map[string]ruleProviderInfo, len(providers))
+	for name, p := range providers {
+		infos[name] = ruleProviderInfo{
+			Name:        p.Name(),
+			Behavior:    p.Behavior().String(),
+			RuleCount:   p.Count(),
+			VehicleType: p.VehicleType().String(),
+			UpdatedAt:   updatedAt(p),
+		}
+	}
+
+	data, _ := json.Marshal(infos)
+	return newCString(string(data))
+}
+
+// selectProviders picks the named provider out of all, or every provider in
+// name order when name is empty.
+func selectProviders[P provider.Provider](all map[string]P, name string) ([]P, error) {
+	if name != "" {
+		p, ok := all[name]
+		if !ok {
+			return nil, errProviderNotFound
+		}
+		return []P{p}, nil
+	}
+
+	names := make([]string, 0, len(all))
+	for n := range all {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	selected := make([]P, 0, len(names))
+	for _, n := range names {
+		selected = append(selected, all[n])
+	}
+	return selected, nil
+}
+
+// refreshProviders updates each provider in turn, reporting its item count
+// or error through cb, then makes the final done call carrying the first
+// error seen. after runs once the updates are finished, before the done call.
+func refreshProviders[P provider.Provider](providers []P, count func(P) int, after func(), cb C.MihomoUpdateCallback, ctx unsafe.Pointer) {
+	var first error
+	for _, p := range providers {
+		err := p.Update()
+		if err != nil && first == nil {
+			first = err
+		}
+		emitUpdate(cb, ctx, p.Name(), int64(count(p)), err, false)
+	}
+	if after != nil {
+		after()
+	}
+	emitUpdate(cb, ctx, "", 0, first, true)
+}
+
+// MihomoRefreshRuleProvider re-fetches the named rule provider, or every one
+// when cName is empty, on a background goroutine. Providers swap their rule
+// set in place, so the next connection matches against the new rules without
+// a config reload. cb receives each provider's rule count, then a final call
+// with an empty name and done set.
+//
+//export MihomoRefreshRuleProvider
+func MihomoRefreshRuleProvider(cName *C.char, cb C.MihomoUpdateCallback, ctx unsafe.Pointer) {
+	name := ""
+	if cName != nil {
+		name = C.GoString(cName)
+	}
+
+	release, err := seize(false, true)
+	if err != nil {
+		emitUpdate(cb, ctx, "", 0, err, true)
+		return
+	}
+	providers, err := selectProviders(tunnel.RuleProviders(), name)
+	release()
+
+	if err != nil {
+		emitUpdate(cb, ctx, "", 0, err, true)
+		return
+	}
+
+	go refreshProviders(providers, provider.RuleProvider.Count, nil, cb, ctx)
+}
+
+type subscriptionInfo struct {
+	Upload   int64   `json:"upload"`
+	Download int64   `json:"download"`
+	Total    int64   `json:"total"`
+	Expire   int64   `json:"expire"`
+	Percent  float64 `json:"percent"`
+}
+
+// providerSubscription reads the subscription-userinfo header a remote
+// provider last stored in the cache file, the same copy mihomo restores on
+// start. Percent is the share of total already used and stays 0 for
+// unmetered plans.
+func providerSubscription(name string) *subscriptionInfo {
+	userinfo := cachefile.Cache().GetSubscriptionInfo(name)
+	if userinfo == "" {
+		return nil
+	}
+
+	si := mihomoProvider.NewSubscriptionInfo(userinfo)
+	info := &subscriptionInfo{
+		Upload:   si.Upload,
+		Download: si.Download,
+		Total:    si.Total,
+		Expire:   si.Expire,
+	}
+	if si.Total > 0 {
+		info.Percent = float64(si.Upload+si.Download) / float64(si.Total) * 100
+	}
+	return info
+}
+
+type proxyProviderInfo struct {
+	Name         string            `json:"name"`
+	VehicleType  string            `json:"vehicleType"`
+	ProxyCount   int               `json:"proxyCount"`
+	Subscription *subscriptionInfo `json:"subscription,omitempty"`
+	Expire       *time.Time        `json:"expire,omitempty"`
+	UpdatedAt    time.Time         `json:"updatedAt"`
+}
+
+// newProxyProviderInfo fills in a provider's subscription details when it
+// has any. Providers that never sent a header omit both subscription and
+// expire.
+func newProxyProviderInfo(p provider.ProxyProvider) proxyProviderInfo {
+	info := proxyProviderInfo{
+		Name:         p.Name(),
+		VehicleType:  p.VehicleType().String(),
+		ProxyCount:   p.Count(),
+		Subscription: providerSubscription(p.Name()),
+		UpdatedAt:    updatedAt(p),
+	}
+	if info.Subscription != nil && info.Subscription.Expire > 0 {
+		expire := time.Unix(info.Subscription.Expire, 0)
+		info.Expire = &expire
+	}
+	return info
+}
+
+// MihomoGetProxyProviders returns every proxy provider keyed by name. The
+// compatible providers mihomo creates for inline group members are left out.
+// A stopped core yields "{}".
+//
+//export MihomoGetProxyProviders
+func MihomoGetProxyProviders() *C.char {
+	release, err := seize(false, true)
+	if err != nil {
+		return newCString("{}")
+	}
+	defer release()
+
+	providers := tunnel.Providers()
+	infos := make(map[string]proxyProviderInfo, len(providers))
+	for name, p := range providers {
+		if p.VehicleType() == provider.Compatible {
+			continue
+		}
+		infos[name] = newProxyProviderInfo(p)
+	}
+
+	data, _ := json.Marshal(infos)
+	return newCString(string(data))
+}
+
+// MihomoGetSubscriptionInfo returns the named provider's subscription usage
+// as JSON, or "" when the provider is unknown or never sent a
+// subscription-userinfo header. expire is a Unix timestamp, 0 when the plan
+// does not expire.
+//
+//export MihomoGetSubscriptionInfo
+func MihomoGetSubscriptionInfo(cProvider *C.char) *C.char {
+	if cProvider == nil {
+		return newCString("")
+	}
+	name := C.GoString(cProvider)
+
+	release, err := seize(false, true)
+	if err != nil {
+		return newCString("")
+	}
+	defer release()
+
+	if _, ok := tunnel.Providers()[name]; !ok {
+		return newCString("")
+	}
+	info := providerSubscription(name)
+	if info == nil {
+		return newCString("")
+	}
+
+	data, _ := json.Marshal(info)
+	return newCString(string(data))
+}
+
+// reselectVanished points every selector whose cached choice is no longer
+// among its members at its first member. Groups rebuild their member list
+// from the provider version on their own; without this a selector would
+// keep the dead name and silently jump back if the node ever reappeared.
+func reselectVanished() {
+	release, err := seize(true, true)
+	if err != nil {
+		return
+	}
+	defer release()
+
+	for group, selected := range cachefile.Cache().SelectedMap() {
+		selector, ok := findSelector(group)
+		if !ok {
+			continue
+		}
+		members := selector.GetProxies(false)
+		if len(members) == 0 {
+			continue
+		}
+
+		alive := false
+		for _, member := range members {
+			if member.Name() == selected {
+				alive = true
+				break
+			}
+		}
+		if alive {
+			continue
+		}
+
+		first := members[0].Name()
+		if err := selector.Set(first); err == nil {
+			cachefile.Cache().SetSelected(group, first)
+		}
+	}
+}
+
+// MihomoRefreshProxyProvider re-fetches the named proxy provider, or every
+// one when cName is empty, on a background goroutine. cb receives each
+// provider's proxy count, then a final call with an empty name and done set,
+// made after selectors that lost their chosen node have been moved to their
+// first member.
+//
+//export MihomoRefreshProxyProvider
+func MihomoRefreshProxyProvider(cName *C.char, cb C.MihomoUpdateCallback, ctx unsafe.Pointer) {
+	name := ""
+	if cName != nil {
+		name = C.GoString(cName)
+	}
+
+	release, err := seize(false, true)
+	if err != nil {
+		emitUpdate(cb, ctx, "", 0, err, true)
+		return
+	}
+	all := make(map[string]provider.ProxyProvider)
+	for n, p := range tunnel.Providers() {
+		if p.VehicleType() != provider.Compatible {
+			all[n] = p
+		}
+	}
+	providers, err := selectProviders(all, name)
+	release()
+
+	if err != nil {
+		emitUpdate(cb, ctx, "", 0, err, true)
+		return
+	}
+
+	go refreshProviders(providers, provider.ProxyProvider.Count, reselectVanished, cb, ctx)
+}
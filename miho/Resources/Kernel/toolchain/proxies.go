@@ -0,0 +1,450 @@
+package main
+
+/*
+#include "capi.h"
+*/
+import "C"
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/metacubex/mihomo/adapter/outboundgroup"
+	"github.com/metacubex/mihomo/component/profile/cachefile"
+	"github.com/metacubex/mihomo/constant"
+	"github.com/metacubex/mihomo/tunnel"
+)
+
+const (
+	defaultTestURL    = "https://www.gstatic.com/generate_204"
+	groupDelayWorkers = 8
+)
+
+var (
+	errProxyNotFound = errors.New("proxy not found")
+	errNotGroup      = errors.New("proxy is not a group")
+	errDelayCanceled = errors.New("canceled")
+)
+
+func init() {
+	resetDelayTests()
+}
+
+// resetDelayTests starts a new generation of delay tests with its own
+// context and WaitGroup. The caller must hold gate exclusively.
+func resetDelayTests() {
+	core.delayCtx, core.delayCancel = context.WithCancel(context.Background())
+	core.delayTests = &sync.WaitGroup{}
+}
+
+// memberLister is satisfied by every outbound group through its embedded
+// GroupBase; GetProxies keeps the order the config declared.
+type memberLister interface {
+	GetProxies(touch bool) []constant.Proxy
+}
+
+func groupMembers(proxy constant.Proxy) ([]constant.Proxy, bool) {
+	lister, ok := proxy.Adapter().(memberLister)
+	if !ok {
+		return nil, false
+	}
+	return lister.GetProxies(false), true
+}
+
+func emitDelay(cb C.MihomoDelayCallback, ctx unsafe.Pointer, name string, delay uint16, err error) {
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+
+	var cErr *C.char
+	if err != nil {
+		cErr = C.CString(err.Error())
+		defer C.free(unsafe.Pointer(cErr))
+	}
+
+	C.mihomo_invoke_delay(cb, cName, C.int(delay), cErr, ctx)
+}
+
+// testDelay runs a single URL test under root, the delay context taken from
+// core. A zero delay without an error still counts as a failure, matching
+// the controller's /delay endpoint, and a test cut short by
+// MihomoCancelDelayTests reports errDelayCanceled.
+func testDelay(root context.Context, proxy constant.Proxy, url string, timeout time.Duration) (uint16, error) {
+	ctx, cancel := context.WithTimeout(root, timeout)
+	defer cancel()
+
+	delay, err := proxy.URLTest(ctx, url, nil)
+	if root.Err() != nil {
+		return 0, errDelayCanceled
+	}
+	if ctx.Err() != nil {
+		return 0, ctx.Err()
+	}
+	if err == nil && delay == 0 {
+		err = errors.New("delay test failed")
+	}
+	return delay, err
+}
+
+func delayParams(cURL *C.char, timeoutMs C.int) (string, time.Duration) {
+	url := defaultTestURL
+	if cURL != nil {
+		if u := C.GoString(cURL); u != "" {
+			url = u
+		}
+	}
+	timeout := time.Duration(timeoutMs) * time.Millisecond
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	return url, timeout
+}
+
+// MihomoProxyDelay tests the named proxy on a background goroutine and
+// reports the result through cb. The proxy is resolved under the read lock,
+// which is released before the test starts, so concurrent tests never queue
+// behind one another.
+//
+//export MihomoProxyDelay
+func MihomoProxyDelay(cName *C.char, cURL *C.char, timeoutMs C.int, cb C.MihomoDelayCallback, ctx unsafe.Pointer) {
+	name := ""
+	if cName != nil {
+		name = C.GoString(cName)
+	}
+	url, timeout := delayParams(cURL, timeoutMs)
+
+	release, err := seize(false, true)
+	if err != nil {
+		emitDelay(cb, ctx, name, 0, err)
+		return
+	}
+	proxy, ok := tunnel.ProxiesWithProviders()[name]
+	root, tests := core.delayCtx, core.delayTests
+	if ok {
+		tests.Add(1)
+	}
+	release()
+
+	if !ok {
+		emitDelay(cb, ctx, name, 0, errProxyNotFound)
+		return
+	}
+
+	go func() {
+		defer tests.Done()
+		delay, err := testDelay(root, proxy, url, timeout)
+		emitDelay(cb, ctx, name, delay, err)
+	}()
+}
+
+// MihomoGroupDelay tests every member of a group with at most
+// groupDelayWorkers tests in flight, firing cb once per member as results
+// arrive. A final call with an empty name marks completion; it carries an
+// error when the group could not be tested at all.
+//
+//export MihomoGroupDelay
+func MihomoGroupDelay(cGroup *C.char, cURL *C.char, timeoutMs C.int, cb C.MihomoDelayCallback, ctx unsafe.Pointer) {
+	name := ""
+	if cGroup != nil {
+		name = C.GoString(cGroup)
+	}
+	url, timeout := delayParams(cURL, timeoutMs)
+
+	release, err := seize(false, true)
+	if err != nil {
+		emitDelay(cb, ctx, "", 0, err)
+		return
+	}
+	group, found := tunnel.Proxies()[name]
+	var (
+		members []constant.Proxy
+		ok      bool
+	)
+	if found {
+		members, ok = groupMembers(group)
+	}
+	root, tests := core.delayCtx, core.delayTests
+	if ok {
+		tests.Add(1)
+	}
+	release()
+
+	if !found {
+		emitDelay(cb, ctx, "", 0, errProxyNotFound)
+		return
+	}
+	if !ok {
+		emitDelay(cb, ctx, "", 0, errNotGroup)
+		return
+	}
+
+	go func() {
+		defer tests.Done()
+		var (
+			wg  sync.WaitGroup
+			emu sync.Mutex
+		)
+		sem := make(chan struct{}, groupDelayWorkers)
+		for _, proxy := range members {
+			select {
+			case sem <- struct{}{}:
+			case <-root.Done():
+			}
+			if root.Err() != nil {
+				break
+			}
+			wg.Add(1)
+			go func(proxy constant.Proxy) {
+				defer wg.Done()
+				defer func() { <-sem }()
+
+				delay, err := testDelay(root, proxy, url, timeout)
+				emu.Lock()
+				emitDelay(cb, ctx, proxy.Name(), delay, err)
+				emu.Unlock()
+			}(proxy)
+		}
+		wg.Wait()
+
+		var err error
+		if root.Err() != nil {
+			err = errDelayCanceled
+		}
+		emitDelay(cb, ctx, "", 0, err)
+	}()
+}
+
+// MihomoCancelDelayTests aborts every proxy and group delay test in flight.
+// Each running test reports "canceled" through its callback, group tests
+// start no further members and end with a "canceled" completion call, and
+// tests started afterwards run normally. It returns only once every
+// canceled test has made its last callback, so the host may free their
+// contexts straight away. It must not be called from a delay callback.
+//
+//export MihomoCancelDelayTests
+func MihomoCancelDelayTests() {
+	release, _ := seize(true, false)
+	core.delayCancel()
+	tests := core.delayTests
+	resetDelayTests()
+	release()
+
+	tests.Wait()
+}
+
+func findSelector(name string) (*outboundgroup.Selector, bool) {
+	proxy, ok := tunnel.Proxies()[name]
+	if !ok || proxy.Type() != constant.Selector {
+		return nil, false
+	}
+	selector, ok := proxy.Adapter().(*outboundgroup.Selector)
+	return selector, ok
+}
+
+// MihomoSelectProxy points a selector group at one of its members and records
+// the choice in the selection cache so it is restored on the next load. It
+// returns -1 when the group is not a selector and -2 when the proxy is not a
+// member.
+//
+//export MihomoSelectProxy
+func MihomoSelectProxy(cGroup *C.char, cName *C.char) C.int {
+	if cGroup == nil || cName == nil {
+		return -1
+	}
+	group, name := C.GoString(cGroup), C.GoString(cName)
+
+	release, err := seize(true, true)
+	if err != nil {
+		return errCode(err)
+	}
+	defer release()
+
+	selector, ok := findSelector(group)
+	if !ok {
+		return fail(-1, fmt.Errorf("%s is not a selector group", group))
+	}
+	if err := selector.Set(name); err != nil {
+		return fail(-2, err)
+	}
+
+	cachefile.Cache().SetSelected(group, name)
+	return 0
+}
+
+//export MihomoGetSelected
+func MihomoGetSelected(cGroup *C.char) *C.char {
+	if cGroup == nil {
+		return newCString("")
+	}
+	group := C.GoString(cGroup)
+
+	release, err := seize(false, true)
+	if err != nil {
+		return newCString("")
+	}
+	defer release()
+
+	selector, ok := findSelector(group)
+	if !ok {
+		return newCString("")
+	}
+	return newCString(selector.Now())
+}
+
+// MihomoGetAllSelected returns a JSON object mapping every selector group to
+// its current member, so a host can restore its picker state in one call.
+//
+//export MihomoGetAllSelected
+func MihomoGetAllSelected() *C.char {
+	release, err := seize(false, true)
+	if err != nil {
+		return newCString("{}")
+	}
+	defer release()
+
+	selected := make(map[string]string)
+	for name := range tunnel.Proxies() {
+		if selector, ok := findSelector(name); ok {
+			selected[name] = selector.Now()
+		}
+	}
+
+	data, _ := json.Marshal(selected)
+	return newCString(string(data))
+}
+
+type proxyInfo struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	UDP     bool     `json:"udp"`
+	Group   bool     `json:"group"`
+	Now     string   `json:"now,omitempty"`
+	Members []string `json:"all,omitempty"`
+}
+
+func newProxyInfo(proxy constant.Proxy) proxyInfo {
+	info := proxyInfo{
+		Name: proxy.Name(),
+		Type: proxy.Type().String(),
+		UDP:  proxy.SupportUDP(),
+	}
+	if members, ok := groupMembers(proxy); ok {
+		info.Group = true
+		info.Members = make([]string, 0, len(members))
+		for _, member := range members {
+			info.Members = append(info.Members, member.Name())
+		}
+		if nower, ok := proxy.Adapter().(interface{ Now() string }); ok {
+			info.Now = nower.Now()
+		}
+	}
+	return info
+}
+
+// MihomoGetProxies returns every proxy and group keyed by name, including
+// the proxies that come from providers, like the controller's /proxies.
+// Group members are listed in config order rather than map order so the
+// output is stable between calls.
+//
+//export MihomoGetProxies
+func MihomoGetProxies() *C.char {
+	release, err := seize(false, true)
+	if err != nil {
+		return newCString("{}")
+	}
+	defer release()
+
+	proxies := tunnel.ProxiesWithProviders()
+	infos := make(map[string]proxyInfo, len(proxies))
+	for name, proxy := range proxies {
+		infos[name] = newProxyInfo(proxy)
+	}
+
+	data, _ := json.Marshal(infos)
+	return newCString(string(data))
+}
+
+// globalOverride remembers what MihomoSetGlobalProxy replaced and the proxy
+// it routes through.
+type globalOverride struct {
+	mode     tunnel.TunnelMode
+	selected string
+	proxy    string
+}
+
+// holdGlobal points GLOBAL back at the override's proxy after a reload
+// rebuilt the group from the file and selection cache. The caller must hold
+// gate.
+func holdGlobal() {
+	if core.override == nil {
+		return
+	}
+	if global, ok := findSelector("GLOBAL"); ok {
+		_ = global.Set(core.override.proxy)
+	}
+}
+
+// MihomoSetGlobalProxy routes all traffic through one proxy by switching to
+// global mode and pointing GLOBAL at it. The override is not written to the
+// selection cache. Calling it again while an override is active only changes
+// the proxy; MihomoClearGlobalProxy still restores the state from before the
+// first call. It returns -1 when the proxy is unknown.
+//
+//export MihomoSetGlobalProxy
+func MihomoSetGlobalProxy(cName *C.char) C.int {
+	if cName == nil {
+		return -1
+	}
+	name := C.GoString(cName)
+
+	release, err := seize(true, true)
+	if err != nil {
+		return errCode(err)
+	}
+	defer release()
+
+	global, ok := findSelector("GLOBAL")
+	if !ok {
+		return fail(ErrCodeInternalError, errProxyNotFound)
+	}
+	previous := global.Now()
+	if err := global.Set(name); err != nil {
+		return fail(-1, fmt.Errorf("%s: %w", name, errProxyNotFound))
+	}
+
+	if core.override == nil {
+		core.override = &globalOverride{mode: tunnel.Mode(), selected: previous}
+	}
+	core.override.proxy = name
+	setMode(tunnel.Global)
+	return ErrCodeSuccess
+}
+
+// MihomoClearGlobalProxy restores the mode and GLOBAL selection that were in
+// effect before MihomoSetGlobalProxy. Without an active override it does
+// nothing.
+//
+//export MihomoClearGlobalProxy
+func MihomoClearGlobalProxy() C.int {
+	release, err := seize(true, true)
+	if err != nil {
+		return errCode(err)
+	}
+	defer release()
+
+	override := core.override
+	if override == nil {
+		return ErrCodeSuccess
+	}
+	core.override = nil
+
+	if global, ok := findSelector("GLOBAL"); ok {
+		_ = global.Set(override.selected)
+	}
+	setMode(override.mode)
+	return ErrCodeSuccess
+}
@@ -0,0 +1,137 @@
+package main
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/metacubex/mihomo/component/resolver"
+	"github.com/metacubex/mihomo/constant"
+	"github.com/metacubex/mihomo/tunnel"
+)
+
+type ruleMatch struct {
+	Mode    string   `json:"mode"`
+	Rule    string   `json:"rule"`
+	Payload string   `json:"payload"`
+	Proxy   string   `json:"proxy"`
+	Chain   []string `json:"chain"`
+}
+
+// matchMetadata walks the rule list the way the tunnel does for a new
+// connection: hosts first, DNS only when a rule asks for an IP, and PASS or
+// UDP-incapable targets skipped. Process lookup is left out since a dry run
+// has no local socket to attribute. A nil rule means the implicit DIRECT
+// fallthrough. It may block on DNS, so it runs on a snapshot of the rules
+// and proxies with gate released.
+func matchMetadata(metadata *constant.Metadata, rules []constant.Rule, proxies map[string]constant.Proxy) (constant.Proxy, constant.Rule) {
+	resolved := false
+	if node, ok := resolver.DefaultHosts.Search(metadata.Host, false); ok {
+		metadata.DstIP, _ = node.RandIP()
+		resolved = true
+	}
+	helper := constant.RuleMatchHelper{
+		ResolveIP: func() {
+			if resolved || metadata.Host == "" || metadata.Resolved() {
+				return
+			}
+			resolved = true
+			ctx, cancel := context.WithTimeout(context.Background(), resolver.DefaultDNSTimeout)
+			defer cancel()
+			if ip, err := resolver.ResolveIP(ctx, metadata.Host); err == nil {
+				metadata.DstIP = ip
+			}
+		},
+	}
+
+	for _, rule := range rules {
+		matched, target := rule.Match(metadata, helper)
+		if !matched {
+			continue
+		}
+		adapter, ok := proxies[target]
+		if !ok {
+			continue
+		}
+
+		passed := false
+		for a := adapter; a != nil; a = a.Unwrap(metadata, false) {
+			if a.Type() == constant.Pass {
+				passed = true
+				break
+			}
+		}
+		if passed {
+			continue
+		}
+		if metadata.NetWork == constant.UDP && !adapter.SupportUDP() {
+			continue
+		}
+		return adapter, rule
+	}
+	return proxies["DIRECT"], nil
+}
+
+// MihomoMatchRule reports which rule and proxy a connection to cHost:cPort
+// would use, without dialing or touching connection statistics. Direct and
+// global modes report their implicit decision with an empty rule. chain
+// lists the groups followed down to the outbound that would dial. Invalid
+// input or a stopped core yields "{}".
+//
+//export MihomoMatchRule
+func MihomoMatchRule(cHost *C.char, cPort C.int, cNetwork *C.char) *C.char {
+	if cHost == nil || cPort <= 0 || cPort > 65535 {
+		return newCString("{}")
+	}
+
+	network := constant.TCP
+	if cNetwork != nil && strings.EqualFold(C.GoString(cNetwork), "udp") {
+		network = constant.UDP
+	}
+	metadata := &constant.Metadata{NetWork: network, Type: constant.INNER}
+	if err := metadata.SetRemoteAddress(net.JoinHostPort(C.GoString(cHost), strconv.Itoa(int(cPort)))); err != nil {
+		return newCString("{}")
+	}
+
+	release, err := seize(false, true)
+	if err != nil {
+		return newCString("{}")
+	}
+	mode, rules, proxies := tunnel.Mode(), tunnel.Rules(), tunnel.Proxies()
+	release()
+
+	var (
+		proxy constant.Proxy
+		rule  constant.Rule
+	)
+	switch mode {
+	case tunnel.Direct:
+		proxy = proxies["DIRECT"]
+	case tunnel.Global:
+		proxy = proxies["GLOBAL"]
+	default:
+		proxy, rule = matchMetadata(metadata, rules, proxies)
+	}
+
+	result := ruleMatch{Mode: mode.String(), Chain: []string{}}
+	if rule != nil {
+		result.Rule = rule.RuleType().String()
+		result.Payload = rule.Payload()
+	}
+	if proxy != nil {
+		result.Proxy = proxy.Name()
+		for a := proxy; a != nil; a = a.Unwrap(metadata, false) {
+			result.Chain = append(result.Chain, a.Name())
+		}
+	}
+
+	data, _ := json.Marshal(result)
+	return newCString(string(data))
+}
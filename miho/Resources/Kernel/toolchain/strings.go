@@ -0,0 +1,39 @@
+package main
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"unsafe"
+)
+
+// Every string an export returns is allocated by newCString and owned by
+// the caller, who must hand it back to MihomoFreeString. Strings passed to
+// callbacks are not covered: the core frees those itself once the callback
+// returns. Build with the mihomo_leakcheck tag to log strings that are
+// never freed and frees of pointers the core never handed out.
+
+func newCString(s string) *C.char {
+	p := C.CString(s)
+	trackAlloc(unsafe.Pointer(p), len(s)+1)
+	return p
+}
+
+func freeCString(p *C.char) {
+	if p == nil {
+		return
+	}
+	if trackFree(unsafe.Pointer(p)) {
+		C.free(unsafe.Pointer(p))
+	}
+}
+
+// MihomoFreeString releases a string returned by any export. Passing NULL is
+// a no-op.
+//
+//export MihomoFreeString
+func MihomoFreeString(ptr *C.char) {
+	freeCString(ptr)
+}
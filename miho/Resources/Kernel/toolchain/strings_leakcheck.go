@@ -0,0 +1,79 @@
+//go:build mihomo_leakcheck
+
+package main
+
+import (
+	"runtime"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/metacubex/mihomo/log"
+)
+
+const (
+	leakAge   = 30 * time.Second
+	leakSweep = 10 * time.Second
+)
+
+type allocation struct {
+	size     int
+	caller   string
+	at       time.Time
+	reported bool
+}
+
+var (
+	allocMu     sync.Mutex
+	allocations = make(map[uintptr]*allocation)
+	leakPump    sync.Once
+)
+
+// trackAlloc records which export handed out p. The caller is two frames
+// up: newCString, then the export itself.
+func trackAlloc(p unsafe.Pointer, size int) {
+	caller := "unknown"
+	if pc, _, _, ok := runtime.Caller(2); ok {
+		if fn := runtime.FuncForPC(pc); fn != nil {
+			caller = fn.Name()
+		}
+	}
+
+	allocMu.Lock()
+	allocations[uintptr(p)] = &allocation{size: size, caller: caller, at: time.Now()}
+	allocMu.Unlock()
+
+	leakPump.Do(func() { go sweepLeaks() })
+}
+
+// trackFree forgets p and reports whether it is safe to free. A pointer the
+// core never returned, or one already freed, is logged and left alone
+// rather than corrupting the heap.
+func trackFree(p unsafe.Pointer) bool {
+	allocMu.Lock()
+	defer allocMu.Unlock()
+
+	if _, ok := allocations[uintptr(p)]; !ok {
+		log.Errorln("[CAPI] free of unknown or already freed string %p", p)
+		return false
+	}
+	delete(allocations, uintptr(p))
+	return true
+}
+
+// sweepLeaks logs every string still outstanding after leakAge, once each.
+func sweepLeaks() {
+	ticker := time.NewTicker(leakSweep)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		allocMu.Lock()
+		for p, a := range allocations {
+			if a.reported || now.Sub(a.at) < leakAge {
+				continue
+			}
+			a.reported = true
+			log.Warnln("[CAPI] string %#x (%d bytes) from %s not freed after %s", p, a.size, a.caller, now.Sub(a.at).Round(time.Second))
+		}
+		allocMu.Unlock()
+	}
+}
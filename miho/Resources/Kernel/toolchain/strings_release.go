@@ -0,0 +1,11 @@
+//go:build !mihomo_leakcheck
+
+package main
+
+import (
+	"unsafe"
+)
+
+func trackAlloc(unsafe.Pointer, int) {}
+
+func trackFree(unsafe.Pointer) bool { return true }
@@ -0,0 +1,188 @@
+package main
+
+/*
+#include "capi.h"
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/metacubex/mihomo/tunnel/statistic"
+)
+
+const (
+	memoryInterval     = time.Second
+	minTrafficInterval = 100 * time.Millisecond
+)
+
+var (
+	telemetryPump     sync.Once
+	trafficIntervalMs atomic.Int64
+	trafficKick       = make(chan struct{}, 1)
+)
+
+func init() {
+	trafficIntervalMs.Store(int64(time.Second / time.Millisecond))
+}
+
+// startTelemetry runs the traffic and memory emitters for the lifetime of the
+// process. Each tick samples while holding the read lock and skips any slot
+// that is empty or a core that is not running.
+func startTelemetry() {
+	telemetryPump.Do(func() {
+		go runTrafficEmitter()
+		go func() {
+			ticker := time.NewTicker(memoryInterval)
+			defer ticker.Stop()
+			for now := range ticker.C {
+				emitMemory(now)
+			}
+		}()
+	})
+}
+
+// runTrafficEmitter ticks at the interval stored in trafficIntervalMs and
+// picks up a new one whenever trafficKick fires. A zero interval stops the
+// ticker until the next change.
+func runTrafficEmitter() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	reset := func() {
+		if interval := time.Duration(trafficIntervalMs.Load()) * time.Millisecond; interval > 0 {
+			ticker.Reset(interval)
+		} else {
+			ticker.Stop()
+		}
+	}
+	reset()
+
+	for {
+		select {
+		case <-trafficKick:
+			reset()
+		case now := <-ticker.C:
+			emitTraffic(now)
+		}
+	}
+}
+
+func emitTraffic(now time.Time) {
+	gate.RLock()
+	defer gate.RUnlock()
+
+	if !core.running || core.trafficCb == nil {
+		return
+	}
+
+	up, down := statistic.DefaultManager.Now()
+	C.mihomo_invoke_traffic(core.trafficCb, C.uint64_t(now.UnixMilli()), C.uint64_t(up), C.uint64_t(down), core.trafficCtx)
+}
+
+func emitMemory(now time.Time) {
+	gate.RLock()
+	defer gate.RUnlock()
+
+	if !core.running || core.memoryCb == nil {
+		return
+	}
+
+	C.mihomo_invoke_memory(core.memoryCb, C.uint64_t(now.UnixMilli()), C.uint64_t(statistic.DefaultManager.Memory()), C.uint64_t(memoryLimit()), core.memoryCtx)
+}
+
+// MihomoSetTrafficInterval changes how often the traffic callback fires.
+// Intervals below 100ms are raised to it, 0 pauses emission while keeping the
+// callback registered, and negative values return -1.
+//
+//export MihomoSetTrafficInterval
+func MihomoSetTrafficInterval(ms C.int) C.int {
+	if ms < 0 {
+		return -1
+	}
+
+	interval := time.Duration(ms) * time.Millisecond
+	if interval > 0 && interval < minTrafficInterval {
+		interval = minTrafficInterval
+	}
+	trafficIntervalMs.Store(int64(interval / time.Millisecond))
+
+	select {
+	case trafficKick <- struct{}{}:
+	default:
+	}
+	return ErrCodeSuccess
+}
+
+//export MihomoSetTrafficCallback
+func MihomoSetTrafficCallback(cb C.MihomoTrafficCallback, ctx unsafe.Pointer) C.int {
+	release, _ := seize(true, false)
+	defer release()
+
+	core.trafficCb = cb
+	core.trafficCtx = ctx
+	startTelemetry()
+	return ErrCodeSuccess
+}
+
+//export MihomoClearTrafficCallback
+func MihomoClearTrafficCallback() {
+	release, _ := seize(true, false)
+	defer release()
+
+	core.trafficCb = nil
+	core.trafficCtx = nil
+}
+
+//export MihomoSetMemoryCallback
+func MihomoSetMemoryCallback(cb C.MihomoMemoryCallback, ctx unsafe.Pointer) C.int {
+	release, _ := seize(true, false)
+	defer release()
+
+	core.memoryCb = cb
+	core.memoryCtx = ctx
+	startTelemetry()
+	return ErrCodeSuccess
+}
+
+//export MihomoClearMemoryCallback
+func MihomoClearMemoryCallback() {
+	release, _ := seize(true, false)
+	defer release()
+
+	core.memoryCb = nil
+	core.memoryCtx = nil
+}
+
+type trafficTotals struct {
+	Upload   int64 `json:"upload"`
+	Download int64 `json:"download"`
+}
+
+// MihomoGetTotalTraffic returns the bytes moved since the core was last
+// started or MihomoResetTraffic was called. Mode switches and config patches
+// leave the totals alone.
+//
+//export MihomoGetTotalTraffic
+func MihomoGetTotalTraffic() *C.char {
+	release, _ := seize(false, false)
+	defer release()
+
+	snapshot := statistic.DefaultManager.Snapshot()
+	data, _ := json.Marshal(trafficTotals{
+		Upload:   snapshot.UploadTotal,
+		Download: snapshot.DownloadTotal,
+	})
+	return newCString(string(data))
+}
+
+//export MihomoResetTraffic
+func MihomoResetTraffic() {
+	release, _ := seize(false, false)
+	defer release()
+
+	statistic.DefaultManager.ResetStatistic()
+}
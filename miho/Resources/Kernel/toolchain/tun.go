@@ -0,0 +1,97 @@
+package main
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/metacubex/mihomo/config"
+	"github.com/metacubex/mihomo/constant"
+	"github.com/metacubex/mihomo/constant/features"
+	"github.com/metacubex/mihomo/listener"
+	"github.com/metacubex/mihomo/tunnel"
+)
+
+var errTunFailed = errors.New("tun listener failed to start, see the log for details")
+
+// holdTun keeps a host-provided TUN device across config reloads, which
+// would otherwise recreate the listener from the file's tun section. The
+// caller must hold gate.
+func holdTun(cfg *config.Config) {
+	if core.tun != nil {
+		cfg.General.Tun = *core.tun
+	}
+}
+
+// MihomoStartTun attaches the TUN inbound to a file descriptor opened by the
+// platform VPN API. Routing and interface detection are left to the host,
+// which owns the device. An empty stack keeps the configured one; a
+// non-positive MTU keeps the configured MTU. It returns -1 for an invalid fd
+// or stack, for a gvisor or mixed stack in a build without gvisor, and when
+// the listener fails to start.
+//
+//export MihomoStartTun
+func MihomoStartTun(fd C.int, mtu C.int, cStack *C.char) C.int {
+	if fd < 0 {
+		return -1
+	}
+
+	release, err := seize(true, true)
+	if err != nil {
+		return errCode(err)
+	}
+	defer release()
+
+	conf := listener.GetTunConf()
+	if cStack != nil {
+		if name := C.GoString(cStack); name != "" {
+			stack, ok := constant.StackTypeMapping[strings.ToLower(name)]
+			if !ok {
+				return fail(-1, fmt.Errorf("unknown tun stack %q", name))
+			}
+			conf.Stack = stack
+		}
+	}
+	if conf.Stack != constant.TunSystem && !features.WithGVisor {
+		return fail(-1, errors.New("this build has no gvisor support"))
+	}
+
+	conf.Enable = true
+	conf.FileDescriptor = int(fd)
+	if mtu > 0 {
+		conf.MTU = uint32(mtu)
+	}
+	conf.AutoRoute = false
+	conf.AutoDetectInterface = false
+
+	listener.ReCreateTun(conf, tunnel.Tunnel)
+	if !listener.GetTunConf().Enable {
+		core.tun = nil
+		return fail(-1, errTunFailed)
+	}
+	core.tun = &conf
+	return ErrCodeSuccess
+}
+
+// MihomoStopTun closes the TUN inbound and keeps it closed across reloads
+// until the next MihomoStartTun or stop.
+//
+//export MihomoStopTun
+func MihomoStopTun() C.int {
+	release, err := seize(true, true)
+	if err != nil {
+		return errCode(err)
+	}
+	defer release()
+
+	conf := listener.GetTunConf()
+	conf.Enable = false
+	listener.ReCreateTun(conf, tunnel.Tunnel)
+	core.tun = &conf
+	return ErrCodeSuccess
+}
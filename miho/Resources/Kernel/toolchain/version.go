@@ -0,0 +1,112 @@
+package main
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/metacubex/mihomo/constant"
+	"github.com/metacubex/mihomo/constant/features"
+)
+
+// compiledFeatures maps feature names to whether this build has them, and is
+// the one source for both MihomoVersion's list and MihomoHasFeature. The
+// tag-dependent entries come from mihomo's features package, whose
+// constants are fixed by build tags; TUN and the sing inbounds are always
+// compiled in, and this core has no MITM support.
+var compiledFeatures = map[string]bool{
+	"tun":        true,
+	"sing":       true,
+	"tfo":        true,
+	"mitm":       false,
+	"gvisor":     features.WithGVisor,
+	"low-memory": features.WithLowMemory,
+	"fake-tcp":   !features.NoFakeTCP,
+	"cmfa":       features.CMFA,
+}
+
+type versionInfo struct {
+	Version   string               `json:"version"`
+	BuildTime string               `json:"buildTime"`
+	GoVersion string               `json:"goVersion"`
+	Platform  string               `json:"platform"`
+	GeoData   map[string]time.Time `json:"geodata"`
+	Features  []string             `json:"features"`
+}
+
+func enabledFeatures() []string {
+	enabled := []string{}
+	for name, ok := range compiledFeatures {
+		if ok {
+			enabled = append(enabled, name)
+		}
+	}
+	sort.Strings(enabled)
+	return enabled
+}
+
+// geoDataTimes reports the modification time of each geo database present in
+// the home dir, keyed by kind. Missing databases are left out. The caller
+// must hold gate, since MihomoInit moves the home dir.
+func geoDataTimes() map[string]time.Time {
+	files := map[string]string{
+		"mmdb":    constant.Path.MMDB(),
+		"asn":     constant.Path.ASN(),
+		"geoip":   constant.Path.GeoIP(),
+		"geosite": constant.Path.GeoSite(),
+	}
+
+	times := make(map[string]time.Time, len(files))
+	for kind, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			times[kind] = info.ModTime()
+		}
+	}
+	return times
+}
+
+// MihomoVersion describes the embedded core and how it was built, listing
+// the features MihomoHasFeature reports as compiled in. It only shares the
+// read lock and may be called before MihomoInit.
+//
+//export MihomoVersion
+func MihomoVersion() *C.char {
+	release, _ := seize(false, false)
+	geoData := geoDataTimes()
+	release()
+
+	info := versionInfo{
+		Version:   constant.Version,
+		BuildTime: constant.BuildTime,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+		GeoData:   geoData,
+		Features:  enabledFeatures(),
+	}
+
+	data, _ := json.Marshal(info)
+	return newCString(string(data))
+}
+
+// MihomoHasFeature returns 1 when the named feature is compiled in and 0
+// otherwise, including for names it does not know. The set is fixed at build
+// time, so it takes no lock.
+//
+//export MihomoHasFeature
+func MihomoHasFeature(cName *C.char) C.int {
+	if cName == nil {
+		return 0
+	}
+	if compiledFeatures[strings.ToLower(C.GoString(cName))] {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestVersionFeaturesMatchHasFeature(t *testing.T) {
+	var info versionInfo
+	if err := json.Unmarshal(call("version", nil).Data.(json.RawMessage), &info); err != nil {
+		t.Fatal(err)
+	}
+
+	listed := make(map[string]bool, len(info.Features))
+	for _, name := range info.Features {
+		listed[name] = true
+	}
+	for name := range compiledFeatures {
+		params := json.RawMessage(fmt.Sprintf(`{"name":%q}`, name))
+		if has := call("hasFeature", params).Data; has != listed[name] {
+			t.Errorf("hasFeature(%q) = %v, but MihomoVersion lists it: %v", name, has, listed[name])
+		}
+	}
+}